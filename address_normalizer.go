@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// AddressNormalizer is implemented by anything that can turn a user entered
+// address into a canonical form. Normalized addresses are stored next to the
+// original ones so locations can be compared without caring about casing,
+// punctuation or abbreviations.
+type AddressNormalizer interface {
+	Normalize(address string) string
+}
+
+// NoopAddressNormalizer : Address normalizer that leaves addresses as they are
+type NoopAddressNormalizer struct{}
+
+// Normalize returns the address without any changes.
+func (NoopAddressNormalizer) Normalize(address string) string {
+	return address
+}
+
+// USPSAddressNormalizer : Address normalizer that follows simplified USPS
+// addressing standards (upper case, no punctuation, standard abbreviations)
+type USPSAddressNormalizer struct{}
+
+// uspsAbbreviations maps common address words to their USPS standard
+// abbreviations.
+var uspsAbbreviations = map[string]string{
+	"ALLEY": "ALY",
+	"APARTMENT": "APT",
+	"AVENUE": "AVE",
+	"BOULEVARD": "BLVD",
+	"BUILDING": "BLDG",
+	"CIRCLE": "CIR",
+	"COURT": "CT",
+	"DRIVE": "DR",
+	"EXPRESSWAY": "EXPY",
+	"FLOOR": "FL",
+	"HIGHWAY": "HWY",
+	"LANE": "LN",
+	"PARKWAY": "PKWY",
+	"PLACE": "PL",
+	"ROAD": "RD",
+	"ROOM": "RM",
+	"SQUARE": "SQ",
+	"STREET": "ST",
+	"SUITE": "STE",
+	"TERRACE": "TER",
+	"TRAIL": "TRL",
+	"NORTH": "N",
+	"SOUTH": "S",
+	"EAST": "E",
+	"WEST": "W",
+	"NORTHEAST": "NE",
+	"NORTHWEST": "NW",
+	"SOUTHEAST": "SE",
+	"SOUTHWEST": "SW",
+}
+
+// Normalize upper cases the address, strips punctuation other than hyphens
+// and slashes, collapses whitespace and abbreviates known words.
+func (USPSAddressNormalizer) Normalize(address string) string {
+	cleaned := strings.Map(func (r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '/' {
+			return unicode.ToUpper(r)
+		}
+		if r == '#' {
+			return r
+		}
+		return ' '
+	}, address)
+
+	words := strings.Fields(cleaned)
+	for i, word := range words {
+		if abbreviation, ok := uspsAbbreviations[word]; ok {
+			words[i] = abbreviation
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// NewAddressNormalizer returns the address normalizer with the specified
+// name. Empty name returns the no-op normalizer.
+func NewAddressNormalizer(name string) (AddressNormalizer, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return NoopAddressNormalizer{}, nil
+	case "usps":
+		return USPSAddressNormalizer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown address normalizer %q", name)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DBStats : Structure that is used as a response of a database connection pool statistics request
+type DBStats struct {
+	MaxOpenConnections int `json:"maxOpenConnections"`
+	OpenConnections int `json:"openConnections"`
+	InUse int `json:"inUse"`
+	Idle int `json:"idle"`
+	WaitCount int64 `json:"waitCount"`
+	WaitDuration string `json:"waitDuration"`
+	WaitDurationMs int64 `json:"waitDurationMs"`
+}
+
+// parseAdminUsers parses a comma separated list of admin user ids.
+func parseAdminUsers(adminUsers string) map[string]bool {
+	admins := map[string]bool{}
+	for _, admin := range strings.Split(adminUsers, ",") {
+		if admin = strings.TrimSpace(admin); admin != "" {
+			admins[admin] = true
+		}
+	}
+
+	return admins
+}
+
+// AdminMiddleware only lets through users whose public id is in the list of
+// admin users. It has to be used after the token verification middleware.
+func AdminMiddleware(adminUsers string) gin.HandlerFunc {
+	admins := parseAdminUsers(adminUsers)
+
+	return func (ctx *gin.Context) {
+		userID, userIDExists := GetUserID(ctx)
+		if !userIDExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		if !admins[userID] {
+			ctx.String(http.StatusForbidden, "Only admins can access this.")
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// newDBStats converts connection pool statistics of a database handle to the
+// structure that is sent in responses.
+func newDBStats(db *sqlx.DB) DBStats {
+	stats := db.Stats()
+
+	return DBStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections: stats.OpenConnections,
+		InUse: stats.InUse,
+		Idle: stats.Idle,
+		WaitCount: stats.WaitCount,
+		WaitDuration: stats.WaitDuration.String(),
+		WaitDurationMs: stats.WaitDuration.Milliseconds(),
+	}
+}
+
+// GetDBStatsHandler is a Gin handler function for getting the statistics of
+// the reader and writer database connection pools.
+func GetDBStatsHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"reader": newDBStats(db.Reader()),
+			"writer": newDBStats(db.Writer()),
+		})
+	}
+}
+
+// dbStatsGauges are the connection pool statistics exported as Prometheus
+// gauges, by their name and help text.
+var dbStatsGauges = []struct {
+	name string
+	help string
+	value func (stats sql.DBStats) float64
+}{
+	{"receipts_db_open_connections", "Number of established connections, both in use and idle.", func (stats sql.DBStats) float64 { return float64(stats.OpenConnections) }},
+	{"receipts_db_in_use_connections", "Number of connections currently in use.", func (stats sql.DBStats) float64 { return float64(stats.InUse) }},
+	{"receipts_db_idle_connections", "Number of idle connections.", func (stats sql.DBStats) float64 { return float64(stats.Idle) }},
+	{"receipts_db_wait_count", "Total number of connections waited for.", func (stats sql.DBStats) float64 { return float64(stats.WaitCount) }},
+	{"receipts_db_wait_duration_seconds", "Total time spent waiting for new connections.", func (stats sql.DBStats) float64 { return stats.WaitDuration.Seconds() }},
+}
+
+// RegisterDBStatsGauges registers the statistics of the reader and writer
+// database connection pools as Prometheus gauges, with the pool label telling
+// them apart. They're the same numbers GET /admin/db-stats returns, read when
+// the gauges are collected.
+func RegisterDBStatsGauges(registerer prometheus.Registerer, db *DB) error {
+	pools := map[string]*sqlx.DB{"reader": db.Reader(), "writer": db.Writer()}
+
+	for _, gauge := range dbStatsGauges {
+		for pool, handle := range pools {
+			gauge, handle := gauge, handle
+			if err := registerer.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: gauge.name,
+				Help: gauge.help,
+				ConstLabels: prometheus.Labels{"pool": pool},
+			}, func () float64 {
+				return gauge.value(handle.Stats())
+			})); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// IntegrityIssue : Structure that should be used for getting entries flagged by integrity checks from database
+type IntegrityIssue struct {
+	PublicID string `db:"public_id" json:"id"`
+	Name string `db:"name" json:"name"`
+}
+
+// integrityCheck : Structure that describes a diagnostic query flagging locations with a specific problem
+type integrityCheck struct {
+	category string
+	query string
+}
+
+// locationIntegrityChecks are the diagnostic queries run for the locations
+// integrity report.
+var locationIntegrityChecks = []integrityCheck{
+	{"blankName", "SELECT public_id, name FROM locations WHERE trim(name) = '' ORDER BY id"},
+	{"blankAddress", "SELECT public_id, name FROM locations WHERE trim(address) = '' ORDER BY id"},
+	{"nameTooLong", "SELECT public_id, name FROM locations WHERE length(name) > 200 ORDER BY id"},
+	{"missingOwner", "SELECT public_id, name FROM locations WHERE created_by NOT IN (SELECT id FROM users) ORDER BY id"},
+	{"missingNormalizedAddress", "SELECT public_id, name FROM locations WHERE normalized_address = '' AND trim(address) != '' ORDER BY id"},
+	{"receiptsWithoutLocation", "SELECT public_id, '' AS name FROM receipts WHERE location_id NOT IN (SELECT id FROM locations) ORDER BY id"},
+	{"photosWithoutLocation", "SELECT public_id, file_name AS name FROM location_photos WHERE location_id NOT IN (SELECT id FROM locations) ORDER BY id"},
+}
+
+// locationFieldsRow : Structure that should be used for getting location fields checked outside of SQL from database
+type locationFieldsRow struct {
+	PublicID string `db:"public_id"`
+	Name string `db:"name"`
+	Country *string `db:"country"`
+	PostalCode *string `db:"postal_code"`
+	Metadata string `db:"metadata"`
+}
+
+// GetLocationsIntegrityHandler is a Gin handler function for getting a report
+// of locations with data problems, like ones that were saved before the
+// validation covering them was added. Flagged entries are grouped by the
+// problem they have, and every problem is listed even if nothing has it.
+func GetLocationsIntegrityHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		report := map[string][]IntegrityIssue{}
+
+		for _, check := range locationIntegrityChecks {
+			issues := []IntegrityIssue{}
+			if err := db.Reader().SelectContext(ctx.Request.Context(), &issues, check.query); err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			report[check.category] = issues
+		}
+
+		// Checks that reuse the request validation rules are done here
+		// instead of in SQL.
+		report["invalidCountry"] = []IntegrityIssue{}
+		report["invalidPostalCode"] = []IntegrityIssue{}
+		report["invalidMetadata"] = []IntegrityIssue{}
+
+		rows, err := db.Reader().QueryxContext(ctx.Request.Context(), "SELECT public_id, name, country, postal_code, metadata FROM locations ORDER BY id")
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var row locationFieldsRow
+			if err := rows.StructScan(&row); err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			issue := IntegrityIssue{PublicID: row.PublicID, Name: row.Name}
+
+			country := ""
+			if row.Country != nil {
+				country = *row.Country
+				if !iso3166Alpha2Codes[country] {
+					report["invalidCountry"] = append(report["invalidCountry"], issue)
+				}
+			}
+			if row.PostalCode != nil && !validPostalCode(*row.PostalCode, country) {
+				report["invalidPostalCode"] = append(report["invalidPostalCode"], issue)
+			}
+
+			var metadata map[string]interface{}
+			if json.Unmarshal([]byte(row.Metadata), &metadata) != nil || metadata == nil {
+				report["invalidMetadata"] = append(report["invalidMetadata"], issue)
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, report)
+	}
+}
+
+// demoLocations are the locations inserted by the seed request. Names are
+// unique per user, so demo locations the user already has are skipped by the
+// insert.
+var demoLocations = []LocationsPostBody{
+	{Name: "Demo Bakery", Street: "12 Baker Street", City: "London", PostalCode: "NW1 6XE", Country: "GB"},
+	{Name: "Demo Grocery", Street: "350 Fifth Avenue", City: "New York", PostalCode: "10118", Country: "US"},
+	{Name: "Demo Market", Street: "Alexanderplatz 1", City: "Berlin", PostalCode: "10178", Country: "DE"},
+	{Name: "Demo Pharmacy", Street: "Rue de Rivoli 99", City: "Paris", PostalCode: "75001", Country: "FR"},
+	{Name: "Demo Hardware Store", Street: "Knez Mihailova 10", City: "Belgrade", PostalCode: "11000", Country: "RS"},
+}
+
+// SeedDemoLocationsHandler is a Gin handler function for inserting the demo
+// locations for the user making the request. Demo locations the user already
+// has are skipped, so seeding more than once doesn't create duplicates.
+func SeedDemoLocationsHandler(db *DB, n AddressNormalizer, g IDGenerator) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		now := time.Now().UTC()
+
+		query := sq.Insert("locations").Columns("public_id", "name", "name_normalized", "address", "normalized_address", "street", "city", "postal_code", "country", "created_by", "created_at", "updated_at")
+		for _, demo := range demoLocations {
+			uuid, err := g.NewID()
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			address := demo.fullAddress()
+			query = query.Values(uuid, demo.Name, normalizeLocationName(demo.Name), address, n.Normalize(address), demo.Street, demo.City, demo.PostalCode, demo.Country, user.ID, now, now)
+		}
+
+		queryString, queryStringArgs, err := query.Suffix("ON CONFLICT DO NOTHING").ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var created int64
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			result, err := tx.Exec(queryString, queryStringArgs...)
+			if err != nil {
+				return err
+			}
+
+			created, err = result.RowsAffected()
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"created": created})
+	}
+}
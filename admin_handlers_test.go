@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterDBStatsGauges(t *testing.T) {
+	db := newTestDB(t)
+
+	registry := prometheus.NewRegistry()
+	if err := RegisterDBStatsGauges(registry, db); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gathered := map[string]map[string]float64{}
+	for _, family := range families {
+		gathered[family.GetName()] = map[string]float64{}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "pool" {
+					gathered[family.GetName()][label.GetValue()] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+
+	for _, gauge := range dbStatsGauges {
+		pools, ok := gathered[gauge.name]
+		if !ok || len(pools) != 2 {
+			t.Fatalf("expected %s for the reader and writer, got %v", gauge.name, pools)
+		}
+	}
+
+	expected := float64(db.Writer().Stats().OpenConnections)
+	if open := gathered["receipts_db_open_connections"]["writer"]; open != expected {
+		t.Fatalf("expected %v open writer connections, got %v", expected, open)
+	}
+}
+
+func TestSeedDemoLocations(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, test := range []struct {
+		userID string
+		body string
+	}{
+		{"u1", `{"created":5}`},
+		{"u2", `{"created":5}`},
+		{"u1", `{"created":0}`},
+	} {
+		router := newTestRouter(test.userID)
+		router.POST("/admin/seed", SeedDemoLocationsHandler(db, NoopAddressNormalizer{}, NanoidGenerator{}))
+
+		if recorder := serveJSON(router, "POST", "/admin/seed", ""); recorder.Code != http.StatusOK || recorder.Body.String() != test.body {
+			t.Fatalf("expected 200 with %s for %s, got %d: %s", test.body, test.userID, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	var name string
+	if err := db.Reader().Get(&name, "select name from locations where created_by = 2 order by id limit 1"); err != nil {
+		t.Fatal(err)
+	}
+	if name != demoLocations[0].Name {
+		t.Fatalf("expected demo locations to keep their names, got %q", name)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIError : Structure that is used as a response body of errors that clients need to tell apart by code
+type APIError struct {
+	Code string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NotFoundHandler is a Gin handler function for requests to routes that
+// don't exist.
+func NotFoundHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusNotFound, APIError{Code: "NOT_FOUND", Message: "Route " + ctx.Request.URL.Path + " doesn't exist."})
+}
+
+// MethodNotAllowedHandler is a Gin handler function for requests to existing
+// routes with a method they don't support.
+func MethodNotAllowedHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusMethodNotAllowed, APIError{Code: "METHOD_NOT_ALLOWED", Message: "Method " + ctx.Request.Method + " is not allowed on " + ctx.Request.URL.Path + "."})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrAttachmentNotFound is returned by attachment stores when there's no
+// attachment with the requested key.
+var ErrAttachmentNotFound = errors.New("Attachment not found.")
+
+// AttachmentStore is implemented by anything that can store uploaded files,
+// like location photos, under a key. Keeping files out of the local disk
+// lets multiple instances of the backend share them.
+type AttachmentStore interface {
+	Put(requestCtx context.Context, key string, data []byte, contentType string) error
+	Get(requestCtx context.Context, key string) (io.ReadCloser, int64, error)
+	Delete(requestCtx context.Context, key string) error
+}
+
+// LocalAttachmentStore : Attachment store that keeps files in a directory on the local disk
+type LocalAttachmentStore struct {
+	Path string
+}
+
+// Put writes the file to the directory, creating the directory if it doesn't
+// exist yet.
+func (store LocalAttachmentStore) Put(requestCtx context.Context, key string, data []byte, contentType string) error {
+	if err := os.MkdirAll(store.Path, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(store.Path, key), data, 0644)
+}
+
+// Get opens the file and returns it together with its size.
+func (store LocalAttachmentStore) Get(requestCtx context.Context, key string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(filepath.Join(store.Path, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, ErrAttachmentNotFound
+		}
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+// Delete removes the file. Files that are already gone aren't an error.
+func (store LocalAttachmentStore) Delete(requestCtx context.Context, key string) error {
+	if err := os.Remove(filepath.Join(store.Path, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// s3RequestTimeout is the time a single request to the S3 compatible storage
+// can take.
+const s3RequestTimeout = 30 * time.Second
+
+// S3AttachmentStore : Attachment store that keeps files in a bucket of an S3 compatible storage, addressed with path style URLs like https://endpoint/bucket/key
+type S3AttachmentStore struct {
+	Endpoint string
+	Bucket string
+	Region string
+	AccessKeyID string
+	SecretAccessKey string
+	Client *http.Client
+}
+
+// Put uploads the file to the bucket.
+func (store S3AttachmentStore) Put(requestCtx context.Context, key string, data []byte, contentType string) error {
+	response, err := store.do(requestCtx, http.MethodPut, key, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	return s3ResponseError(response)
+}
+
+// Get downloads the file from the bucket. The returned body has to be closed.
+func (store S3AttachmentStore) Get(requestCtx context.Context, key string) (io.ReadCloser, int64, error) {
+	response, err := store.do(requestCtx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, 0, ErrAttachmentNotFound
+	}
+	if err := s3ResponseError(response); err != nil {
+		response.Body.Close()
+		return nil, 0, err
+	}
+
+	return response.Body, response.ContentLength, nil
+}
+
+// Delete removes the file from the bucket. Files that are already gone
+// aren't an error.
+func (store S3AttachmentStore) Delete(requestCtx context.Context, key string) error {
+	response, err := store.do(requestCtx, http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return s3ResponseError(response)
+}
+
+// do sends a signed request for the object with specified key.
+func (store S3AttachmentStore) do(requestCtx context.Context, method string, key string, body []byte, contentType string) (*http.Response, error) {
+	objectURL := strings.TrimRight(store.Endpoint, "/") + "/" + s3URIEscape(store.Bucket) + "/" + s3URIEscape(key)
+
+	request, err := http.NewRequest(method, objectURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(requestCtx)
+
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	store.sign(request, body, time.Now().UTC())
+
+	client := store.Client
+	if client == nil {
+		client = &http.Client{Timeout: s3RequestTimeout}
+	}
+
+	return client.Do(request)
+}
+
+// sign adds AWS Signature Version 4 headers to the request, which is how S3
+// compatible storages authenticate requests. Every header already set on the
+// request is signed together with the host.
+func (store S3AttachmentStore) sign(request *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		s3CanonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + store.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+store.SecretAccessKey), date)
+	key = hmacSHA256(key, store.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+store.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// s3CanonicalQuery encodes query arguments the way Signature Version 4
+// expects, sorted by name and then value.
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	arguments := []string{}
+	for _, name := range names {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+
+		for _, value := range values {
+			arguments = append(arguments, s3URIEscape(name)+"="+s3URIEscape(value))
+		}
+	}
+
+	return strings.Join(arguments, "&")
+}
+
+// s3ResponseError returns an error describing the response if the storage
+// didn't accept the request.
+func s3ResponseError(response *http.Response) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+	return fmt.Errorf("Attachment storage responded with %s: %s", response.Status, strings.TrimSpace(string(message)))
+}
+
+// s3URIEscape escapes a path segment the way Signature Version 4 expects,
+// which leaves only unreserved characters as they are.
+func s3URIEscape(segment string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+
+	return escaped.String()
+}
+
+// sha256Hex returns the hex encoded SHA-256 hash of data.
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with specified key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// NewAttachmentStore returns the attachment store with the specified name.
+// Empty name returns the local disk store keeping files in path, and s3 is
+// used as the S3 compatible store once its endpoint, bucket and keys are
+// checked. Region defaults to us-east-1.
+func NewAttachmentStore(name string, path string, s3 S3AttachmentStore) (AttachmentStore, error) {
+	switch strings.ToLower(name) {
+	case "", "local":
+		return LocalAttachmentStore{Path: path}, nil
+	case "s3":
+		if s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+			return nil, fmt.Errorf("S3 attachment store needs an endpoint, bucket, access key id and secret access key")
+		}
+		if s3.Region == "" {
+			s3.Region = "us-east-1"
+		}
+		return s3, nil
+	default:
+		return nil, fmt.Errorf("unknown attachment store %q", name)
+	}
+}
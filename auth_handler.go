@@ -8,7 +8,6 @@ import (
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
 )
@@ -21,12 +20,12 @@ type User struct {
 
 // UserCheck checks if there is a specified user in the database. If there is,
 // does nothing. If there is not, inserts the data in database.
-func UserCheck(user goth.User, db *sqlx.DB) {
+func UserCheck(user goth.User, db *DB) {
 	query := sq.Select("public_id").From("users").Where(sq.Eq{"public_id": user.UserID})
 	queryString, queryStringArgs, _ := query.ToSql()
 
 	users := []User{}
-	if err := db.Select(&users, queryString, queryStringArgs...); err != nil {
+	if err := db.Reader().Select(&users, queryString, queryStringArgs...); err != nil {
 		log.Fatalln(err.Error())
 	}
 
@@ -34,7 +33,7 @@ func UserCheck(user goth.User, db *sqlx.DB) {
 		insertQuery := sq.Insert("users").Columns("public_id", "real_name").Values(user.UserID, user.Email)
 		insertQueryString, insertArgs, _ := insertQuery.ToSql()
 
-		tx, err := db.Begin()
+		tx, err := db.Writer().Begin()
 		if err != nil {
 			log.Fatalln(err.Error())
 		}
@@ -49,7 +48,7 @@ func UserCheck(user goth.User, db *sqlx.DB) {
 	}
 }
 
-func AuthHandler(db *sqlx.DB) gin.HandlerFunc {
+func AuthHandler(db *DB) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		tmpContext := context.WithValue(ctx.Request.Context(), "provider", "google")
 		newRequestContext := ctx.Request.WithContext(tmpContext)
@@ -65,7 +64,7 @@ func AuthHandler(db *sqlx.DB) gin.HandlerFunc {
 	}
 }
 
-func AuthCallbackHandler(db *sqlx.DB) gin.HandlerFunc {
+func AuthCallbackHandler(db *DB) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		tmpContext := context.WithValue(ctx.Request.Context(), "provider", "google")
 		newRequestContext := ctx.Request.WithContext(tmpContext)
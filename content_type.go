@@ -0,0 +1,42 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSONContentTypeMiddleware rejects POST, PUT and DELETE requests that have a
+// body which isn't sent as application/json, so handlers don't try to bind
+// JSON from something else. Routes listed in exemptRoutes, like uploads that
+// use multipart bodies, are not checked.
+func JSONContentTypeMiddleware(exemptRoutes ...string) gin.HandlerFunc {
+	exempt := map[string]bool{}
+	for _, route := range exemptRoutes {
+		exempt[route] = true
+	}
+
+	return func (ctx *gin.Context) {
+		switch ctx.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodDelete:
+		default:
+			ctx.Next()
+			return
+		}
+
+		if ctx.Request.ContentLength == 0 || exempt[ctx.FullPath()] {
+			ctx.Next()
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			ctx.String(http.StatusUnsupportedMediaType, "Request body must be sent as application/json!")
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
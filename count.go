@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// userLocationCount gets the number of locations the user has from the
+// location_count counter of the user, which triggers on the locations table
+// keep up to date in the same transaction as every insert and delete.
+func userLocationCount(requestCtx context.Context, db *sqlx.DB, userID int) (int64, error) {
+	var count int64
+	err := db.GetContext(requestCtx, &count, "SELECT location_count FROM users WHERE id = ?", userID)
+	return count, err
+}
+
+// SetCountHeaders sets X-Approximate-Count to the number of locations the
+// user has, read from a counter so it doesn't need a count. It doesn't take
+// filters into account, which is what makes it approximate for filtered
+// lists. When the request has exactCount=true, X-Total-Count is set to the
+// exact number of locations the list query matches across all pages, which
+// needs a full count.
+func SetCountHeaders(ctx *gin.Context, db *sqlx.DB, listQuery sq.SelectBuilder, userID int) error {
+	count, err := userLocationCount(ctx.Request.Context(), db, userID)
+	if err != nil {
+		return err
+	}
+	ctx.Header("X-Approximate-Count", strconv.FormatInt(count, 10))
+
+	if ctx.Query("exactCount") != "true" {
+		return nil
+	}
+
+	countQueryString, countQueryStringArgs, err := sq.Select("COUNT(*)").FromSelect(listQuery.RemoveLimit().RemoveOffset(), "list").ToSql()
+	if err != nil {
+		return err
+	}
+
+	if err := db.GetContext(ctx.Request.Context(), &count, countQueryString, countQueryStringArgs...); err != nil {
+		return err
+	}
+
+	ctx.Header("X-Total-Count", strconv.FormatInt(count, 10))
+	return nil
+}
@@ -1,13 +1,236 @@
 package main
 
 import (
+	"context"
 	"os"
+	"strings"
 
 	// DB stuff
 	"github.com/jmoiron/sqlx"
 )
 
-func generateDatabase() (*sqlx.DB, error) {
+// columnMigration : Structure that describes a column added to a table after its initial schema
+type columnMigration struct {
+	table string
+	column string
+	definition string
+}
+
+var columnMigrations = []columnMigration{
+	{"locations", "normalized_address", "text not null default ''"},
+	{"locations", "country", "text"},
+	{"locations", "region", "text"},
+	{"locations", "is_default", "boolean not null default 0"},
+	{"locations", "favorite", "boolean not null default 0"},
+	{"locations", "metadata", "text not null default '{}'"},
+	{"locations", "street", "text"},
+	{"locations", "city", "text"},
+	{"locations", "postal_code", "text"},
+	{"users", "default_currency", "text"},
+	{"users", "locale", "text"},
+	{"locations", "visit_count", "integer not null default 0"},
+	{"locations", "active", "boolean not null default 1"},
+	{"locations", "sort_order", "integer"},
+	{"locations", "name_normalized", "text"},
+	{"locations", "category", "text"},
+	{"users", "location_count", "integer not null default 0"},
+}
+
+// columnBackfills are statements filling in values of existing rows, run once
+// right after the column they're keyed by as table.column is added.
+var columnBackfills = map[string]string{
+	"locations.visit_count": "update locations set visit_count = (select count(*) from receipts where receipts.location_id = locations.id)",
+	"users.location_count": "update users set location_count = (select count(*) from locations where locations.created_by = users.id)",
+}
+
+// columnBackfillFuncs fill in values of existing rows that can't be computed
+// in SQL, run once right after the column they're keyed by as table.column is
+// added, after its statement in columnBackfills if it has one.
+var columnBackfillFuncs = map[string]func (db *sqlx.DB) error{
+	"locations.name_normalized": backfillLocationNamesNormalized,
+}
+
+// backfillLocationNamesNormalized fills in name_normalized of existing
+// locations with normalizeLocationName, since SQLite lower only folds ASCII
+// letters and keys of other names wouldn't match the keys new writes get.
+// Only the first of a user's names that differ just in casing gets the key,
+// the rest stay NULL, which the unique index allows, until they're renamed.
+func backfillLocationNamesNormalized(db *sqlx.DB) error {
+	type locationName struct {
+		ID int `db:"id"`
+		CreatedBy int `db:"created_by"`
+		Name string `db:"name"`
+	}
+	type userName struct {
+		createdBy int
+		name string
+	}
+
+	locations := []locationName{}
+	if err := db.Select(&locations, "select id, created_by, name from locations order by id"); err != nil {
+		return err
+	}
+
+	return runTx(context.Background(), db, func (tx *sqlx.Tx) error {
+		taken := map[userName]bool{}
+		for _, location := range locations {
+			key := userName{location.CreatedBy, normalizeLocationName(location.Name)}
+			if taken[key] {
+				continue
+			}
+			taken[key] = true
+
+			if _, err := tx.Exec("update locations set name_normalized = ? where id = ?", key.name, location.ID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// statementMigrations are idempotent statements creating tables and indexes
+// that were introduced after the initial schema.
+var statementMigrations = []string{
+	"create unique index if not exists locations_default_per_user on locations(created_by) where is_default = 1",
+	"create unique index if not exists locations_name_per_user on locations(created_by, name)",
+	`create table if not exists location_photos (
+		id integer primary key autoincrement unique,
+		location_id integer not null unique,
+		public_id text not null unique,
+		file_name text not null,
+		thumbnail_file_name text not null,
+		content_type text not null,
+		size integer not null,
+		created_at datetime default current_timestamp,
+
+		foreign key (location_id) references locations(id)
+	);`,
+	"create index if not exists locations_name_lower on locations(created_by, lower(name))",
+	`create table if not exists export_jobs (
+		id integer primary key autoincrement unique,
+		public_id text not null unique,
+		created_by integer not null,
+		status text not null,
+		timezone text not null,
+		file_name text,
+		error text,
+		created_at datetime default current_timestamp,
+		finished_at datetime,
+		expires_at datetime,
+
+		foreign key (created_by) references users(id)
+	);`,
+	"create index if not exists export_jobs_status on export_jobs(status)",
+	"create index if not exists locations_sort_order on locations(created_by, sort_order)",
+	"drop index if exists locations_name_normalized",
+	"create unique index if not exists locations_name_normalized_per_user on locations(created_by, name_normalized)",
+	// Location counts of users are kept up to date by triggers, so no write
+	// can forget to update them.
+	`create trigger if not exists users_location_count_insert after insert on locations
+	begin
+		update users set location_count = location_count + 1 where id = new.created_by;
+	end;`,
+	`create trigger if not exists users_location_count_delete after delete on locations
+	begin
+		update users set location_count = location_count - 1 where id = old.created_by;
+	end;`,
+	`create trigger if not exists users_location_count_update after update of created_by on locations when new.created_by is not old.created_by
+	begin
+		update users set location_count = location_count - 1 where id = old.created_by;
+		update users set location_count = location_count + 1 where id = new.created_by;
+	end;`,
+	// SQLite can't add check constraints to an existing table, so the length
+	// limits of location names and addresses are enforced with triggers.
+	`create trigger if not exists locations_length_insert before insert on locations
+	begin
+		select raise(abort, 'Name can''t be longer than 200 characters!') where length(new.name) > 200;
+		select raise(abort, 'Address can''t be longer than 512 characters!') where length(new.address) > 512;
+	end;`,
+	`create trigger if not exists locations_length_update before update of name, address on locations
+	begin
+		select raise(abort, 'Name can''t be longer than 200 characters!') where length(new.name) > 200;
+		select raise(abort, 'Address can''t be longer than 512 characters!') where length(new.address) > 512;
+	end;`,
+}
+
+// globalLocationNameColumn is how the name column of locations was defined
+// when names had to be unique across all users.
+const globalLocationNameColumn = "name text not null unique"
+
+// dropGlobalLocationNameUniqueness rebuilds the locations table of databases
+// created when names had to be unique across all users, since SQLite can't
+// drop the unique constraint of a column. Names are unique per user through
+// an index instead. Indexes and triggers of the table are dropped together
+// with it and created again by statementMigrations.
+func dropGlobalLocationNameUniqueness(db *sqlx.DB) error {
+	var schema string
+	if err := db.Get(&schema, "select sql from sqlite_master where type = 'table' and name = 'locations'"); err != nil {
+		return err
+	}
+
+	if !strings.Contains(schema, globalLocationNameColumn) {
+		return nil
+	}
+
+	// SQLite upper cases the start of stored statements, so the new table is
+	// named by replacing everything before the column definitions.
+	schema = strings.Replace(schema, globalLocationNameColumn, "name text not null", 1)
+	schema = "create table locations_rebuilt " + schema[strings.Index(schema, "("):]
+
+	return runTx(context.Background(), db, func (tx *sqlx.Tx) error {
+		for _, statement := range []string{
+			schema,
+			"insert into locations_rebuilt select * from locations",
+			"drop table locations",
+			"alter table locations_rebuilt rename to locations",
+		} {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DB : Structure that holds separate database handles for reading and writing.
+// SQLite allows only one writer at a time, so all writes are serialized
+// through a single connection, while reads use a larger pool of read only
+// connections that WAL mode lets run alongside the writer.
+type DB struct {
+	reader *sqlx.DB
+	writer *sqlx.DB
+	jsonFunctions bool
+}
+
+// Reader returns the database handle that should be used for reads.
+func (db *DB) Reader() *sqlx.DB {
+	return db.reader
+}
+
+// Writer returns the database handle that should be used for writes.
+func (db *DB) Writer() *sqlx.DB {
+	return db.writer
+}
+
+// HasJSONFunctions checks if SQLite was built with its JSON functions, which
+// go-sqlite3 only includes with the sqlite_json build tag.
+func (db *DB) HasJSONFunctions() bool {
+	return db.jsonFunctions
+}
+
+// writerDataSource is the data source of the write connection. It switches
+// the database to WAL mode, which is persistent, so readers don't have to.
+const writerDataSource = "file:receipts.db?_journal_mode=WAL&_busy_timeout=5000"
+
+// readerDataSource is the data source of the read only connections.
+const readerDataSource = "file:receipts.db?mode=ro&_busy_timeout=5000"
+
+// maxReadConnections is the maximum number of open read connections.
+const maxReadConnections = 8
+
+func generateDatabase() (*DB, error) {
 	userTableSchema := `
 	create table users (
 		id integer primary key autoincrement unique,
@@ -19,7 +242,7 @@ func generateDatabase() (*sqlx.DB, error) {
 		id integer primary key autoincrement unique,
 		created_by integer not null,
 		public_id text not null unique,
-		name text not null unique,
+		name text not null,
 		address text not null,
 		created_at datetime default current_timestamp,
 		updated_at datetime default current_timestamp,
@@ -66,10 +289,11 @@ func generateDatabase() (*sqlx.DB, error) {
 	if _, err := os.Stat("receipts.db"); err != nil {
 		os.Create("receipts.db")
 
-		db, err := sqlx.Connect("sqlite3", "./receipts.db")
+		db, err := sqlx.Connect("sqlite3", writerDataSource)
 		if err != nil {
 			return nil, err
 		}
+		db.SetMaxOpenConns(1)
 
 		if _, err := db.Exec(userTableSchema); err != nil {
 			return nil, err
@@ -87,13 +311,88 @@ func generateDatabase() (*sqlx.DB, error) {
 			return nil, err
 		}
 
-		return db, nil
+		if err := migrateDatabase(db); err != nil {
+			return nil, err
+		}
+
+		return openReader(db)
+	}
+
+	db, err := sqlx.Connect("sqlite3", writerDataSource)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if err := migrateDatabase(db); err != nil {
+		return nil, err
 	}
 
-	db, err := sqlx.Connect("sqlite3", "./receipts.db")
+	return openReader(db)
+}
+
+// openReader opens the read only connection pool next to an already opened
+// writer connection.
+func openReader(writer *sqlx.DB) (*DB, error) {
+	reader, err := sqlx.Connect("sqlite3", readerDataSource)
 	if err != nil {
 		return nil, err
 	}
+	reader.SetMaxOpenConns(maxReadConnections)
+
+	// Any error means the function doesn't exist, since the query itself
+	// can't fail otherwise.
+	var object string
+	jsonFunctions := reader.Get(&object, "select json('{}')") == nil
+
+	return &DB{reader: reader, writer: writer, jsonFunctions: jsonFunctions}, nil
+}
+
+// migrateDatabase adds columns, tables and indexes that were introduced after
+// the initial schema to databases that don't have them yet. It's run on every
+// start so databases created by older versions of the backend keep working.
+func migrateDatabase(db *sqlx.DB) error {
+	for _, migration := range columnMigrations {
+		columns := []string{}
+		if err := db.Select(&columns, "select name from pragma_table_info(?)", migration.table); err != nil {
+			return err
+		}
+
+		exists := false
+		for _, column := range columns {
+			if column == migration.column {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			if _, err := db.Exec("alter table " + migration.table + " add column " + migration.column + " " + migration.definition); err != nil {
+				return err
+			}
+
+			if backfill, ok := columnBackfills[migration.table+"."+migration.column]; ok {
+				if _, err := db.Exec(backfill); err != nil {
+					return err
+				}
+			}
+			if backfill, ok := columnBackfillFuncs[migration.table+"."+migration.column]; ok {
+				if err := backfill(db); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err := dropGlobalLocationNameUniqueness(db); err != nil {
+		return err
+	}
+
+	for _, migration := range statementMigrations {
+		if _, err := db.Exec(migration); err != nil {
+			return err
+		}
+	}
 
-	return db, nil
+	return nil
 }
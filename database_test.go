@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestDB creates a new database in a temporary directory, with users u1
+// and u2. The database is removed once the test is done.
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "receipts-archive-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is always created in the working directory.
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := generateDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func () {
+		db.Reader().Close()
+		db.Writer().Close()
+		os.Chdir(workingDir)
+		os.RemoveAll(dir)
+	})
+
+	db.Writer().MustExec("insert into users (public_id, real_name) values ('u1', 'User One'), ('u2', 'User Two')")
+
+	return db
+}
+
+// newTestRouter creates a router that handles every request as the user with
+// specified public id, like the JWT middleware does for valid tokens.
+func newTestRouter(userID string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(func (ctx *gin.Context) {
+		ctx.Set("userID", userID)
+		ctx.Next()
+	})
+
+	return router
+}
+
+// serveJSON sends a request with a JSON body to the router and returns the
+// recorded response.
+func serveJSON(router http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	return recorder
+}
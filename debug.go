@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// debugSQLUsers are the users that can get the executed SQL in list
+// responses. It's empty unless the DEBUG_SQL environment variable is set to
+// true, in which case it holds the admin users.
+var debugSQLUsers = map[string]bool{}
+
+// DebugInfo : Structure that is used for sending the executed query in debug responses
+type DebugInfo struct {
+	SQL string `json:"sql"`
+	Args []interface{} `json:"args"`
+}
+
+// ListResponse sends the entries of a list request with the status. When
+// debugging is enabled, the user is allowed to debug and the request has
+// debug=true in its query, entries are sent under data, together with the
+// executed query under _debug.
+func ListResponse(ctx *gin.Context, status int, data interface{}, queryString string, queryStringArgs []interface{}) {
+	userID, _ := GetUserID(ctx)
+	if ctx.Query("debug") != "true" || !debugSQLUsers[userID] {
+		ctx.JSON(status, data)
+		return
+	}
+
+	if queryStringArgs == nil {
+		queryStringArgs = []interface{}{}
+	}
+
+	ctx.JSON(status, gin.H{
+		"data": data,
+		"_debug": DebugInfo{SQL: queryString, Args: queryStringArgs},
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrPreconditionFailed is returned by checkIfMatch when the entry was
+// changed since the client got the ETag it sent.
+var ErrPreconditionFailed = errors.New("Entry was changed in the meantime, get it again and retry the update.")
+
+// requireIfMatch makes updates without the If-Match header fail with 428
+// Precondition Required. It can be turned on with the REQUIRE_IF_MATCH
+// environment variable.
+var requireIfMatch = false
+
+// entityETag generates the ETag of an entry from its public id and the time
+// it was last updated, so it changes on every update.
+func entityETag(publicID string, updatedAt time.Time) string {
+	hash := sha1.Sum([]byte(publicID + "|" + updatedAt.UTC().Format(time.RFC3339Nano)))
+	return "\"" + hex.EncodeToString(hash[:]) + "\""
+}
+
+// ifMatchHeader gets the If-Match header of an update request. Responds with
+// 428 and returns false if the header is required but missing.
+func ifMatchHeader(ctx *gin.Context) (string, bool) {
+	ifMatch := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if ifMatch == "" && requireIfMatch {
+		ctx.String(http.StatusPreconditionRequired, "If-Match header with the ETag of the entry must be specified!")
+		return "", false
+	}
+
+	return ifMatch, true
+}
+
+// checkIfMatch compares the If-Match header against the current ETag of the
+// entry with specified public id inside the update transaction, so nothing
+// can change the entry between the check and the update. Empty header
+// matches anything. If-Match uses strong comparison, so weak ETags never
+// match.
+func checkIfMatch(tx *sqlx.Tx, table string, publicID string, ifMatch string) error {
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+
+	queryString, queryStringArgs, err := sq.Select("updated_at").From(table).Where(sq.Eq{"public_id": publicID}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	var updatedAt time.Time
+	if err := tx.Get(&updatedAt, queryString, queryStringArgs...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	etag := entityETag(publicID, updatedAt)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return nil
+		}
+	}
+
+	return ErrPreconditionFailed
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Statuses an export job goes through. Pending jobs wait for the worker,
+// finished jobs are either done or failed, and done jobs become expired once
+// their file is removed.
+const (
+	exportJobPending = "pending"
+	exportJobRunning = "running"
+	exportJobDone = "done"
+	exportJobFailed = "failed"
+	exportJobExpired = "expired"
+)
+
+// defaultExportJobTTL is how long the file of a finished export job can be
+// downloaded before it's removed.
+const defaultExportJobTTL = 24 * time.Hour
+
+// exportJobTimeout is the time a single export job can take before it's
+// cancelled and marked as failed.
+const exportJobTimeout = 10 * time.Minute
+
+// exportJobsPollInterval is how often the worker looks for pending jobs and
+// expired files when it isn't woken up by a new job.
+const exportJobsPollInterval = time.Minute
+
+// ExportJob : Structure that should be used for getting export job information from database
+type ExportJob struct {
+	ID int `db:"id" json:"-"`
+	PublicID string `db:"public_id" json:"id"`
+	CreatedBy int `db:"created_by" json:"-"`
+	Status string `db:"status" json:"status"`
+	Timezone string `db:"timezone" json:"-"`
+	FileName *string `db:"file_name" json:"-"`
+	Error *string `db:"error" json:"error"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+	FinishedAt *time.Time `db:"finished_at" json:"finishedAt"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expiresAt"`
+	DownloadURL *string `db:"-" json:"downloadUrl"`
+}
+
+// exportJobColumns are the columns that should be selected for scanning into
+// the ExportJob structure.
+const exportJobColumns = "id, public_id, created_by, status, timezone, file_name, error, created_at, finished_at, expires_at"
+
+// addDownloadURL sets the link the file of a done job can be downloaded from.
+func (job *ExportJob) addDownloadURL() {
+	if job.Status != exportJobDone {
+		return
+	}
+
+	href := apiLink("/locations/export/download/"+job.PublicID, nil).Href
+	job.DownloadURL = &href
+}
+
+// ExportJobs : Structure that holds the background worker building files of export jobs
+type ExportJobs struct {
+	db *DB
+	path string
+	ttl time.Duration
+	wake chan struct{}
+}
+
+// NewExportJobs creates the export jobs worker storing files in path. ttl is
+// how long files can be downloaded, if it's empty defaultExportJobTTL is used.
+// The worker has to be started with Start.
+func NewExportJobs(db *DB, path string, ttl string) (*ExportJobs, error) {
+	jobs := &ExportJobs{db: db, path: path, ttl: defaultExportJobTTL, wake: make(chan struct{}, 1)}
+
+	if ttl != "" {
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, err
+		}
+		jobs.ttl = duration
+	}
+
+	return jobs, nil
+}
+
+// Start runs the worker in the background. Jobs that were running when the
+// backend stopped are queued again, since their files were never finished.
+func (jobs *ExportJobs) Start() error {
+	queryString, queryStringArgs, err := sq.Update("export_jobs").Set("status", exportJobPending).Where(sq.Eq{"status": exportJobRunning}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	if _, err := jobs.db.Writer().Exec(queryString, queryStringArgs...); err != nil {
+		return err
+	}
+
+	go jobs.work()
+
+	return nil
+}
+
+// notify wakes the worker up so it picks up a new job right away. It never
+// blocks, if the worker is busy it'll get to the job when it's done.
+func (jobs *ExportJobs) notify() {
+	select {
+	case jobs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work runs jobs one at a time, so heavy exports don't compete for the
+// database, and removes expired files in between.
+func (jobs *ExportJobs) work() {
+	ticker := time.NewTicker(exportJobsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			ran, err := jobs.runNext()
+			if err != nil {
+				log.Println("Export job failed: " + err.Error())
+			}
+			if !ran {
+				break
+			}
+		}
+
+		if err := jobs.removeExpired(); err != nil {
+			log.Println("Removing expired exports failed: " + err.Error())
+		}
+
+		select {
+		case <-jobs.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNext runs the oldest pending job. It returns false if there was no job
+// to run.
+func (jobs *ExportJobs) runNext() (bool, error) {
+	queryString, queryStringArgs, err := sq.Select(exportJobColumns).From("export_jobs").Where(sq.Eq{"status": exportJobPending}).OrderBy("id").Limit(1).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	var job ExportJob
+	if err := jobs.db.Reader().Get(&job, queryString, queryStringArgs...); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := jobs.setStatus(job.ID, sq.Eq{"status": exportJobRunning}); err != nil {
+		return true, err
+	}
+
+	fileName := job.PublicID + ".json"
+	if err := jobs.build(job, fileName); err != nil {
+		os.Remove(filepath.Join(jobs.path, fileName))
+		return true, jobs.setStatus(job.ID, sq.Eq{"status": exportJobFailed, "error": err.Error(), "finished_at": time.Now().UTC()})
+	}
+
+	now := time.Now().UTC()
+	return true, jobs.setStatus(job.ID, sq.Eq{"status": exportJobDone, "file_name": fileName, "finished_at": now, "expires_at": now.Add(jobs.ttl)})
+}
+
+// build writes the full export of the user that created the job to a file.
+func (jobs *ExportJobs) build(job ExportJob, fileName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportJobTimeout)
+	defer cancel()
+
+	tz, err := time.LoadLocation(job.Timezone)
+	if err != nil {
+		tz = time.UTC
+	}
+
+	if err := os.MkdirAll(jobs.path, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(jobs.path, fileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rows, err := queryLocationsFullExport(ctx, jobs.db, job.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	if err := writeLocationsFullExport(file, rows, tz, nil); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// setStatus sets the columns of a job.
+func (jobs *ExportJobs) setStatus(jobID int, columns sq.Eq) error {
+	queryString, queryStringArgs, err := sq.Update("export_jobs").SetMap(columns).Where(sq.Eq{"id": jobID}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	return withTx(context.Background(), jobs.db.Writer(), func (tx *sqlx.Tx) error {
+		_, err := tx.Exec(queryString, queryStringArgs...)
+		return err
+	})
+}
+
+// removeExpired removes files of done jobs that have expired and marks the
+// jobs as expired.
+func (jobs *ExportJobs) removeExpired() error {
+	queryString, queryStringArgs, err := sq.Select(exportJobColumns).From("export_jobs").Where(sq.Eq{"status": exportJobDone}).Where(sq.Lt{"expires_at": time.Now().UTC()}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	expired := []ExportJob{}
+	if err := jobs.db.Reader().Select(&expired, queryString, queryStringArgs...); err != nil {
+		return err
+	}
+
+	for _, job := range expired {
+		if job.FileName != nil {
+			if err := os.Remove(filepath.Join(jobs.path, *job.FileName)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if err := jobs.setStatus(job.ID, sq.Eq{"status": exportJobExpired, "file_name": nil}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// userExportJob gets the export job with specified public id if it was
+// created by the user. Responds with an error and returns false if it wasn't.
+func userExportJob(ctx *gin.Context, db *DB, publicID string, userID int) (ExportJob, bool) {
+	queryString, queryStringArgs, err := sq.Select(exportJobColumns).From("export_jobs").Where(sq.Eq{"public_id": publicID, "created_by": userID}).ToSql()
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return ExportJob{}, false
+	}
+
+	var job ExportJob
+	if err := db.Reader().GetContext(ctx.Request.Context(), &job, queryString, queryStringArgs...); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			ctx.String(http.StatusNotFound, "Export job not found.")
+			break
+		default:
+			ctx.String(http.StatusInternalServerError, err.Error())
+		}
+		return ExportJob{}, false
+	}
+
+	return job, true
+}
+
+// PostExportJobHandler is a Gin handler function for starting a full export
+// of locations in the background. A user can only have one export pending or
+// running at a time.
+func PostExportJobHandler(db *DB, g IDGenerator, jobs *ExportJobs) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		uuid, err := g.NewID()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		activeQueryString, activeQueryStringArgs, err := sq.Select("COUNT(*)").From("export_jobs").Where(sq.Eq{"created_by": user.ID, "status": []string{exportJobPending, exportJobRunning}}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		job := ExportJob{PublicID: uuid, CreatedBy: user.ID, Status: exportJobPending, Timezone: RequestTimezone(ctx).String(), CreatedAt: time.Now().UTC()}
+
+		insertQueryString, insertQueryStringArgs, err := sq.Insert("export_jobs").Columns("public_id", "created_by", "status", "timezone", "created_at").Values(job.PublicID, job.CreatedBy, job.Status, job.Timezone, job.CreatedAt).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		active := 0
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if err := tx.Get(&active, activeQueryString, activeQueryStringArgs...); err != nil || active > 0 {
+				return err
+			}
+
+			_, err := tx.Exec(insertQueryString, insertQueryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		if active > 0 {
+			ctx.String(http.StatusConflict, "An export is already in progress, wait for it to finish before starting another one.")
+			return
+		}
+
+		jobs.notify()
+
+		job.localize(RequestTimezone(ctx))
+		ctx.Header("Location", apiLink("/locations/export/jobs/"+job.PublicID, nil).Href)
+		ctx.JSON(http.StatusAccepted, job)
+	}
+}
+
+// GetExportJobHandler is a Gin handler function for getting the status of an
+// export job, with the link to download its file once it's done.
+func GetExportJobHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		job, jobExists := userExportJob(ctx, db, ctx.Param("id"), user.ID)
+		if !jobExists {
+			return
+		}
+
+		job.addDownloadURL()
+		job.localize(RequestTimezone(ctx))
+		ctx.JSON(http.StatusOK, job)
+	}
+}
+
+// DownloadExportJobHandler is a Gin handler function for downloading the file
+// of a done export job.
+func DownloadExportJobHandler(db *DB, jobs *ExportJobs) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		job, jobExists := userExportJob(ctx, db, ctx.Param("id"), user.ID)
+		if !jobExists {
+			return
+		}
+
+		if job.Status != exportJobDone || job.FileName == nil {
+			ctx.String(http.StatusNotFound, "Export job has no file to download, its status is "+job.Status+".")
+			return
+		}
+
+		ctx.Header("Content-Disposition", `attachment; filename="locations-full.json"`)
+		ctx.Header("Content-Type", "application/json; charset=utf-8")
+		ctx.File(filepath.Join(jobs.path, *job.FileName))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Features : Structure that holds which optional features are enabled in a deployment
+type Features struct {
+	all bool
+	enabled map[string]bool
+}
+
+// NewFeatures parses a comma separated list of enabled features. Empty list
+// enables all features, so deployments that don't configure them keep
+// everything.
+func NewFeatures(list string) Features {
+	features := Features{all: strings.TrimSpace(list) == "", enabled: map[string]bool{}}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			features.enabled[name] = true
+		}
+	}
+
+	return features
+}
+
+// Enabled checks if the feature with specified name is enabled.
+func (features Features) Enabled(name string) bool {
+	return features.all || features.enabled[name]
+}
+
+// requireFeature makes routes it's used on respond as if they don't exist
+// when the feature with specified name isn't enabled.
+func requireFeature(features Features, name string) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		if !features.Enabled(name) {
+			NotFoundHandler(ctx)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
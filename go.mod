@@ -9,9 +9,12 @@ require (
 	github.com/gin-contrib/sessions v0.0.3
 	github.com/gin-gonic/gin v1.6.2
 	github.com/go-playground/validator v9.31.0+incompatible
+	github.com/golang/protobuf v1.4.2
 	github.com/jkomyno/nanoid v0.0.0-20170914145641-30c81465692e
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/joho/godotenv v1.3.0
 	github.com/markbates/goth v1.64.0
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
+	github.com/prometheus/client_golang v1.7.1
+	gopkg.in/go-playground/validator.v9 v9.29.1
 )
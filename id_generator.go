@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jkomyno/nanoid"
+)
+
+// IDGenerator is implemented by anything that can generate public ids of
+// newly created entries.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// NanoidGenerator : ID generator that generates random nanoid ids, with the
+// default nanoid length and alphabet unless they're set
+type NanoidGenerator struct {
+	Alphabet string
+	Length int
+}
+
+// NewID returns a new random nanoid.
+func (generator NanoidGenerator) NewID() (string, error) {
+	if generator.Alphabet == "" {
+		if generator.Length == 0 {
+			return nanoid.Nanoid()
+		}
+		return nanoid.Nanoid(generator.Length)
+	}
+
+	length := generator.Length
+	if length == 0 {
+		length = nanoid.GetDefaults().Size
+	}
+
+	// Random bytes are masked to the smallest power of two that covers the
+	// alphabet and the ones that fall outside of it are skipped, so every
+	// character is equally likely.
+	mask := 1
+	for mask < len(generator.Alphabet)-1 {
+		mask = mask<<1 | 1
+	}
+
+	id := make([]byte, 0, length)
+	random := make([]byte, length*2)
+	for len(id) < length {
+		if _, err := rand.Read(random); err != nil {
+			return "", err
+		}
+
+		for _, b := range random {
+			if index := int(b) & mask; index < len(generator.Alphabet) {
+				id = append(id, generator.Alphabet[index])
+				if len(id) == length {
+					break
+				}
+			}
+		}
+	}
+
+	return string(id), nil
+}
+
+// UUIDv7Generator : ID generator that generates UUIDv7 ids, which start with
+// a millisecond timestamp so ids created later sort after earlier ones
+type UUIDv7Generator struct{}
+
+// NewID returns a new UUIDv7 in its canonical textual form.
+func (UUIDv7Generator) NewID() (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[6:]); err != nil {
+		return "", err
+	}
+
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(uuid[:6], timestamp[2:])
+
+	uuid[6] = uuid[6]&0x0f | 0x70
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	encoded := hex.EncodeToString(uuid[:])
+	return encoded[:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:], nil
+}
+
+// minNanoidLength is the shortest nanoid length that can be configured.
+const minNanoidLength = 8
+
+// maxNanoidLength is the longest nanoid length that can be configured.
+const maxNanoidLength = 64
+
+// NewIDGenerator returns the ID generator with specified name. Empty name
+// returns the nanoid generator, which uses nanoidAlphabet and nanoidLength
+// if they're set. Changing them only affects ids of new entries.
+func NewIDGenerator(name string, nanoidAlphabet string, nanoidLength int) (IDGenerator, error) {
+	switch strings.ToLower(name) {
+	case "", "nanoid":
+		if nanoidLength != 0 && (nanoidLength < minNanoidLength || nanoidLength > maxNanoidLength) {
+			return nil, fmt.Errorf("nanoid length must be between %d and %d", minNanoidLength, maxNanoidLength)
+		}
+
+		seen := map[rune]bool{}
+		for _, r := range nanoidAlphabet {
+			if r > unicode.MaxASCII || seen[r] {
+				return nil, fmt.Errorf("nanoid alphabet must consist of unique ASCII characters")
+			}
+			seen[r] = true
+		}
+		if nanoidAlphabet != "" && len(seen) < 2 {
+			return nil, fmt.Errorf("nanoid alphabet must have at least 2 characters")
+		}
+
+		return NanoidGenerator{Alphabet: nanoidAlphabet, Length: nanoidLength}, nil
+	case "uuidv7":
+		return UUIDv7Generator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown id generator %q", name)
+	}
+}
@@ -2,14 +2,12 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
-	"github.com/jkomyno/nanoid"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -22,7 +20,7 @@ type ItemsGetQuery struct {
 // ItemsPostBody : Structure that should be used for getting json from body of a post request for items
 type ItemsPostBody struct {
 	// CreatedBy string `json:"createdBy" validate:"required"`
-	Name string `json:"name" validate:"required"`
+	Name string `json:"name" validate:"required,max=200"`
 	Price float32 `json:"price" validate:"required"`
 	Unit string `json:"unit" validate:"required"`
 }
@@ -30,7 +28,7 @@ type ItemsPostBody struct {
 // ItemsPutBody : Structure that should be used for getting json from body of a put request for items
 type ItemsPutBody struct {
 	PublicID string `json:"id" validate:"required"`
-	Name string `json:"name"`
+	Name string `json:"name" validate:"omitempty,max=200"`
 	Price float32 `json:"price"`
 	Unit string `json:"unit"`
 }
@@ -51,11 +49,11 @@ type Item struct {
 }
 
 // GetItemsHandler is a Gin handler function for getting items.
-func GetItemsHandler(db *sqlx.DB) gin.HandlerFunc {
+func GetItemsHandler(db *DB) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -82,20 +80,26 @@ func GetItemsHandler(db *sqlx.DB) gin.HandlerFunc {
 		}
 
 		items := []Item{}
-		if err := db.Select(&items, queryString, queryStringArgs...); err != nil {
-			log.Fatalln(err.Error())
+		if err := db.Reader().SelectContext(ctx.Request.Context(), &items, queryString, queryStringArgs...); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		tz := RequestTimezone(ctx)
+		for i := range items {
+			items[i].localize(tz)
 		}
 
-		ctx.JSON(http.StatusOK, items)
+		ListResponse(ctx, http.StatusOK, items, queryString, queryStringArgs)
 	}
 }
 
 // PostItemsHandler is a Gin handler function for adding new items.
-func PostItemsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+func PostItemsHandler(db *DB, v *validator.Validate, g IDGenerator) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -111,9 +115,9 @@ func PostItemsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
-		uuid, err := nanoid.Nanoid()
+		uuid, err := g.NewID()
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
@@ -127,32 +131,24 @@ func PostItemsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			_, err := tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		if err := tx.Commit(); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		ctx.Status(http.StatusOK)
+		ctx.Status(http.StatusCreated)
 	}
 }
 
 // PutItemsHandler is a Gin handler function for updating items.
-func PutItemsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+func PutItemsHandler(db *DB, v *validator.Validate) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -168,7 +164,7 @@ func PutItemsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		query := sq.Update("items")
 
@@ -190,32 +186,24 @@ func PutItemsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		if err := tx.Commit(); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			_, err := tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		ctx.Status(http.StatusOK)
+		ctx.Status(http.StatusNoContent)
 	}
 }
 
 // DeleteItemsHandler is a Gin handler function for deleting items.
-func DeleteItemsHandler (db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+func DeleteItemsHandler (db *DB, v *validator.Validate) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -231,7 +219,7 @@ func DeleteItemsHandler (db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		query := sq.Delete("items").Where(sq.Eq{"public_id": itemData.PublicID, "created_by": user.ID})
 		queryString, queryStringArgs, err := query.ToSql()
@@ -240,18 +228,14 @@ func DeleteItemsHandler (db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			_, err := tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			log.Fatalln(err.Error())
-		}
-
-		tx.Commit()
-
-		ctx.Status(http.StatusOK)
+		ctx.Status(http.StatusNoContent)
 	}
 }
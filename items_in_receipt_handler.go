@@ -2,13 +2,11 @@ package main
 
 import (
 	"database/sql"
-	"log"
 	"net/http"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
-	"github.com/jkomyno/nanoid"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -43,11 +41,11 @@ type ItemInReceipt struct {
 
 // GetItemsInReceiptHandler is a Gin handler function for getting items from
 // a specific receipt.
-func GetItemsInReceiptHandler(db *sqlx.DB) gin.HandlerFunc {
+func GetItemsInReceiptHandler(db *DB) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -57,7 +55,7 @@ func GetItemsInReceiptHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		query := sq.Select("items_in_receipt.public_id, items.public_id as item_public_id, items.name as item_name, items.price as item_price, items.unit as item_unit, items_in_receipt.amount").From("items_in_receipt").Join("items ON items.id = items_in_receipt.item_id").Join("receipts ON receipts.id = items_in_receipt.receipt_id").Where(sq.Eq{"receipts.public_id": receiptPublicID, "receipts.created_by": user.ID})
 
@@ -68,7 +66,7 @@ func GetItemsInReceiptHandler(db *sqlx.DB) gin.HandlerFunc {
 		}
 
 		items := []ItemInReceipt{}
-		if err := db.Select(&items, queryString, queryStringArgs...); err != nil {
+		if err := db.Reader().SelectContext(ctx.Request.Context(), &items, queryString, queryStringArgs...); err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -79,11 +77,11 @@ func GetItemsInReceiptHandler(db *sqlx.DB) gin.HandlerFunc {
 
 // PostItemsInReceiptHandler is a Gin handler function for adding new items to
 // a specific receipt.
-func PostItemsInReceiptHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+func PostItemsInReceiptHandler(db *DB, v *validator.Validate, g IDGenerator) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -99,7 +97,7 @@ func PostItemsInReceiptHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFu
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		receiptIDQuery := sq.Select("id").From("receipts").Where(sq.Eq{"public_id": itemData.ReceiptID, "created_by": user.ID})
 
@@ -110,7 +108,7 @@ func PostItemsInReceiptHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFu
 		}
 
 		receipt := StructID{}
-		if err := db.Get(&receipt, receiptIDQueryString, receiptIDQueryStringArgs...); err != nil {
+		if err := db.Reader().GetContext(ctx.Request.Context(), &receipt, receiptIDQueryString, receiptIDQueryStringArgs...); err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -124,12 +122,12 @@ func PostItemsInReceiptHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFu
 		}
 
 		item := StructID{}
-		if err := db.Get(&item, itemIDQueryString, itemIDQueryStringArgs...); err != nil {
+		if err := db.Reader().GetContext(ctx.Request.Context(), &item, itemIDQueryString, itemIDQueryStringArgs...); err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		uuid, err := nanoid.Nanoid()
+		uuid, err := g.NewID()
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
@@ -143,33 +141,25 @@ func PostItemsInReceiptHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFu
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		if err := tx.Commit(); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			_, err := tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		ctx.Status(http.StatusOK)
+		ctx.Status(http.StatusCreated)
 	}
 }
 
 // PutItemsInReceiptHandler is a Gin handler function for updating items in a
 // specific receipt.
-func PutItemsInReceiptHandler(db *sqlx.DB) gin.HandlerFunc {
+func PutItemsInReceiptHandler(db *DB, v *validator.Validate) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -179,7 +169,13 @@ func PutItemsInReceiptHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		err := v.Struct(itemData)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		userOwnsQuery := sq.Select("items_in_receipt.id").From("items_in_receipt").Join("receipts on receipts.id = items_in_receipt.receipt_id").Where(sq.Eq{"items_in_receipt.public_id": itemData.PublicID, "receipts.created_by": user.ID})
 
@@ -190,7 +186,7 @@ func PutItemsInReceiptHandler(db *sqlx.DB) gin.HandlerFunc {
 		}
 
 		item := StructID{}
-		if err := db.Get(&item, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
+		if err := db.Reader().GetContext(ctx.Request.Context(), &item, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
 			ctx.String(http.StatusUnauthorized, "Not authrized to edit specified item from receipt.")
 			return
 		}
@@ -207,29 +203,25 @@ func PutItemsInReceiptHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			_, err := tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			log.Fatalln(err.Error())
-		}
-
-		tx.Commit()
-
-		ctx.Status(http.StatusOK)
+		ctx.Status(http.StatusNoContent)
 	}
 }
 
 // DeleteItemsInReceiptHandler is a Gin handler function for deleting items from
 // a specific receipt.
-func DeleteItemsInReceiptHandler (db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+func DeleteItemsInReceiptHandler (db *DB, v *validator.Validate) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -245,7 +237,7 @@ func DeleteItemsInReceiptHandler (db *sqlx.DB, v *validator.Validate) gin.Handle
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		userOwnsQuery := sq.Select("items_in_receipt.id").From("items_in_receipt").Join("receipts ON receipts.id = items_in_receipt.receipt_id")
 
@@ -258,7 +250,7 @@ func DeleteItemsInReceiptHandler (db *sqlx.DB, v *validator.Validate) gin.Handle
 		userOwnsQueryString, userOwnsQueryStringArgs, err := userOwnsQuery.ToSql()
 
 		var item StructID
-		if err := db.Get(&item, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
+		if err := db.Reader().GetContext(ctx.Request.Context(), &item, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
 			switch err {
 			case sql.ErrNoRows:
 				ctx.String(http.StatusUnauthorized, "Not authrized to delete specified item from receipt.")
@@ -283,22 +275,14 @@ func DeleteItemsInReceiptHandler (db *sqlx.DB, v *validator.Validate) gin.Handle
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		if err := tx.Commit(); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			_, err := tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		ctx.Status(http.StatusOK)
+		ctx.Status(http.StatusNoContent)
 	}
 }
@@ -10,7 +10,6 @@ import (
 	"github.com/gbrlsnchs/jwt/v3"
 	"github.com/gin-gonic/gin"
 	"github.com/jkomyno/nanoid"
-	"github.com/jmoiron/sqlx"
 	"github.com/markbates/goth"
 )
 
@@ -35,21 +34,36 @@ func RefreshToken(payload JWTPayload) (string, bool) {
 	return "", false
 }
 
+// authErrorResponse aborts the request with 401 and a code telling clients why
+// the token was rejected, so they can tell a missing token apart from one
+// that has expired or was never valid.
+func authErrorResponse(ctx *gin.Context, code string, message string) {
+	ctx.JSON(http.StatusUnauthorized, APIError{Code: code, Message: message})
+	ctx.Abort()
+}
+
+// userIDErrorResponse aborts the request the same way as a rejected token
+// when there's no user id in the request context, which happens when a route
+// that needs the user isn't behind the token verification middleware.
+func userIDErrorResponse(ctx *gin.Context) {
+	authErrorResponse(ctx, "INVALID_TOKEN", "User id not found in authorization token.")
+}
+
 // TokenVerificationMiddleware verifies token sent via request in the cookie and
 // checks if the user exists in the database. Afther that adds user id as a
 // property inside request context.
-func TokenVerificationMiddleware(db *sqlx.DB) gin.HandlerFunc {
+func TokenVerificationMiddleware(db *DB) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		token, err := ctx.Cookie("token")
 		if err != nil {
 			switch err {
 			case http.ErrNoCookie:
-				ctx.String(http.StatusUnauthorized, "No authorization token cookie found!")
+				authErrorResponse(ctx, "NO_AUTH_HEADER", "No authorization token cookie found!")
 				break
 			default:
 				ctx.String(http.StatusInternalServerError, err.Error())
+				ctx.Abort()
 			}
-			ctx.Abort()
 			return
 		}
 
@@ -63,12 +77,15 @@ func TokenVerificationMiddleware(db *sqlx.DB) gin.HandlerFunc {
 		if err != nil {
 			switch err {
 			case jwt.ErrExpValidation:
-				ctx.String(http.StatusUnauthorized, "The token has expired!")
+				authErrorResponse(ctx, "EXPIRED_TOKEN", "The token has expired!")
+				break
+			case jwt.ErrHMACVerification, jwt.ErrAlgValidation:
+				authErrorResponse(ctx, "INVALID_TOKEN", "The token signature is not valid!")
 				break
 			default:
-				ctx.String(http.StatusInternalServerError, err.Error())
+				// Everything else comes from decoding the token.
+				authErrorResponse(ctx, "MALFORMED_TOKEN", "The token is malformed!")
 			}
-			ctx.Abort()
 			return
 		}
 
@@ -77,15 +94,15 @@ func TokenVerificationMiddleware(db *sqlx.DB) gin.HandlerFunc {
 		userNameQueryString, userNameQueryStringArgs, err := userNameQuery.ToSql()
 		
 		var user StructID
-		if err := db.Get(&user, userNameQueryString, userNameQueryStringArgs...); err != nil {
+		if err := db.Reader().Get(&user, userNameQueryString, userNameQueryStringArgs...); err != nil {
 			switch err {
 			case sql.ErrNoRows:
-				ctx.String(http.StatusUnauthorized, "Hey you! You are not supposed to be here! Please go away!")
+				authErrorResponse(ctx, "INVALID_TOKEN", "Hey you! You are not supposed to be here! Please go away!")
 				break
 			default:
 				ctx.String(http.StatusInternalServerError, err.Error())
+				ctx.Abort()
 			}
-			ctx.Abort()
 			return
 		}
 
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Routes that need the user respond like the token was rejected when there's
+// no user id in the request context.
+func TestMissingUserIDResponse(t *testing.T) {
+	db := newTestDB(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/locations", GetLocationHandler(db))
+	router.GET("/items", GetItemsHandler(db))
+	router.GET("/receipts", GetReceiptsHandler(db))
+
+	for _, path := range []string{"/locations", "/items", "/receipts"} {
+		recorder := serveJSON(router, "GET", path, "")
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 on %s, got %d: %s", path, recorder.Code, recorder.Body.String())
+		}
+
+		var apiError APIError
+		if err := json.Unmarshal(recorder.Body.Bytes(), &apiError); err != nil || apiError.Code != "INVALID_TOKEN" {
+			t.Fatalf("expected an INVALID_TOKEN error on %s, got %s", path, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// apiBasePath is the path the API is served under, like "/api/v1" when it's
+// behind a proxy that adds a prefix. It's prepended to links in responses and
+// can be changed with the API_BASE_PATH environment variable.
+var apiBasePath = ""
+
+// Link : Structure that is used for sending a link to a related resource
+type Link struct {
+	Href string `json:"href"`
+}
+
+// LocationLinks : Structure that is used for sending links related to a location
+type LocationLinks struct {
+	Self Link `json:"self"`
+	Receipts Link `json:"receipts"`
+	Collection Link `json:"collection"`
+}
+
+// apiLink builds the link to a path of the API with optional query
+// parameters.
+func apiLink(path string, query url.Values) Link {
+	href := strings.TrimRight(apiBasePath, "/") + path
+	if len(query) > 0 {
+		href += "?" + query.Encode()
+	}
+
+	return Link{Href: href}
+}
+
+// addLinks sets links to the location itself, its receipts and the list of
+// locations.
+func (location *Location) addLinks() {
+	location.Links = &LocationLinks{
+		Self: apiLink("/locations", url.Values{"id": {location.PublicID}}),
+		Receipts: apiLink("/receipts", url.Values{"locationId": {location.PublicID}}),
+		Collection: apiLink("/locations", nil),
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+)
+
+// maxListLimit is the maximum number of entries that can be requested in one
+// page of a list on routes that don't have their own page size.
+const maxListLimit = 200
+
+// PageSize : Structure that holds the number of entries returned in one page of a list when the client doesn't ask for a specific number, and the most it can ask for
+type PageSize struct {
+	Default uint64
+	Max uint64
+}
+
+// defaultPageSize is used on routes that aren't in listPageSizes. Lists aren't
+// paginated unless the client asks for it.
+var defaultPageSize = PageSize{Default: 0, Max: maxListLimit}
+
+// listPageSizes maps full paths of list routes to their page sizes. Max of 0
+// means there's no cap. Entries can be added or changed with the PAGE_SIZES
+// environment variable.
+var listPageSizes = map[string]PageSize{
+	"/locations": {Default: 0, Max: maxListLimit},
+	"/locations/suggest": {Default: 10, Max: 10},
+}
+
+// parsePageSizes parses a comma separated list of route=default:max entries,
+// like "/locations=50:500,/locations/suggest=5:20", into listPageSizes.
+func parsePageSizes(list string) error {
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Page size %q should be in route=default:max format!", entry)
+		}
+
+		sizes := strings.SplitN(parts[1], ":", 2)
+		if len(sizes) != 2 {
+			return fmt.Errorf("Page size %q should be in route=default:max format!", entry)
+		}
+
+		defaultSize, err := strconv.ParseUint(strings.TrimSpace(sizes[0]), 10, 64)
+		if err != nil {
+			return err
+		}
+		maxSize, err := strconv.ParseUint(strings.TrimSpace(sizes[1]), 10, 64)
+		if err != nil {
+			return err
+		}
+		if maxSize > 0 && defaultSize > maxSize {
+			return fmt.Errorf("Default page size of %s can't be larger than its max!", parts[0])
+		}
+
+		listPageSizes[strings.TrimSpace(parts[0])] = PageSize{Default: defaultSize, Max: maxSize}
+	}
+
+	return nil
+}
+
+// pageSizeFor gets the page size of the route with specified full path.
+func pageSizeFor(route string) PageSize {
+	if pageSize, ok := listPageSizes[route]; ok {
+		return pageSize
+	}
+
+	return defaultPageSize
+}
+
+// Limit gets the number of entries that should be returned when the client
+// asks for limit of them, 0 meaning it didn't ask. 0 is returned when entries
+// shouldn't be limited.
+func (pageSize PageSize) Limit(limit uint64) uint64 {
+	if limit == 0 {
+		limit = pageSize.Default
+	}
+	if pageSize.Max > 0 && limit > pageSize.Max {
+		limit = pageSize.Max
+	}
+
+	return limit
+}
+
+// maxListOffset is the largest offset that can be requested on list requests,
+// since SQLite has to step through every skipped row. It can be changed with
+// the MAX_LIST_OFFSET environment variable.
+var maxListOffset uint64 = 10000
+
+// ListParams : Structure that should be used for getting pagination, sorting and date range query data on list requests
+type ListParams struct {
+	Limit uint64 `form:"limit"`
+	Offset uint64 `form:"offset"`
+	OrderBy string `form:"orderBy"`
+	Order string `form:"order"`
+	From string `form:"from"`
+	To string `form:"to"`
+	After string `form:"after"`
+}
+
+// cursorTime is how created timestamps are compared by the after cursor.
+// Both the column and the cursor go through it, so they're cut to the same
+// precision no matter in which format the timestamp was stored.
+const cursorTime = "strftime('%%Y-%%m-%%d %%H:%%M:%%f', %s)"
+
+// afterCursor applies the after cursor, which is the createdAt and id of the
+// last entry of the previous page separated by a comma, like
+// "2020-05-01T10:00:00Z,abc". Only entries after the cursor in the order
+// keysetOrder sorts them in are returned, so entries created in the same
+// second are neither skipped nor repeated.
+func afterCursor(query sq.SelectBuilder, after string, direction string, dateColumn string, idColumn string) (sq.SelectBuilder, error) {
+	parts := strings.SplitN(after, ",", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return query, fmt.Errorf("After must be in createdAt,id format!")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		return query, err
+	}
+
+	comparison := ">"
+	if direction == "DESC" {
+		comparison = "<"
+	}
+
+	column := fmt.Sprintf(cursorTime, dateColumn)
+	cursor := fmt.Sprintf(cursorTime, "?")
+	value := createdAt.UTC().Format("2006-01-02 15:04:05.999999999")
+
+	return query.Where("("+column+" "+comparison+" "+cursor+" OR ("+column+" = "+cursor+" AND "+idColumn+" "+comparison+" ?))", value, value, parts[1]), nil
+}
+
+// keysetOrder sorts entries by dateColumn and then idColumn, which is the
+// order the after cursor pages through.
+func keysetOrder(query sq.SelectBuilder, direction string, dateColumn string, idColumn string) sq.SelectBuilder {
+	return query.OrderBy(fmt.Sprintf(cursorTime, dateColumn)+" "+direction+" NULLS LAST", idColumn+" "+direction)
+}
+
+// dateRange limits a select query to entries whose dateColumn is at or after
+// from and before to. Both are RFC 3339 timestamps and either can be empty
+// to leave that end open. Timestamps are compared in UTC, to the second.
+func dateRange(query sq.SelectBuilder, from string, to string, dateColumn string) (sq.SelectBuilder, error) {
+	if from != "" {
+		fromTime, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return query, err
+		}
+
+		query = query.Where("datetime("+dateColumn+") >= datetime(?)", fromTime.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if to != "" {
+		toTime, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return query, err
+		}
+
+		query = query.Where("datetime("+dateColumn+") < datetime(?)", toTime.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	return query, nil
+}
+
+// buildListQuery applies pagination, sorting and date range from list params
+// to a select query, so every list endpoint handles them the same way. The
+// page size is looked up by route, which should be the full path of the
+// request. allowedSortCols maps the values clients can send as orderBy to the
+// columns or expressions they sort by, anything else is rejected, and so is
+// order that isn't asc or desc. Entries with NULL values are sorted last in
+// both directions. The date range is applied
+// to dateColumn, and the after cursor to dateColumn and idColumn. Sorting by
+// createdAt sorts by both of them, in the order the cursor pages through,
+// and is what after implies. Lists without an idColumn don't accept the
+// cursor.
+func buildListQuery(base sq.SelectBuilder, params ListParams, route string, allowedSortCols map[string]string, dateColumn string, idColumn string) (sq.SelectBuilder, error) {
+	query := base
+
+	if params.Offset > maxListOffset {
+		return query, fmt.Errorf("Offset can't be larger than %d! To get further results send after with the createdAt and id of the last entry received, like after=2020-05-01T10:00:00Z,abc, instead of offset.", maxListOffset)
+	}
+
+	query, err := dateRange(query, params.From, params.To, dateColumn)
+	if err != nil {
+		return query, err
+	}
+
+	direction := "ASC"
+	switch strings.ToLower(params.Order) {
+	case "", "asc":
+	case "desc":
+		direction = "DESC"
+	default:
+		return query, fmt.Errorf("Order must be one of asc or desc!")
+	}
+
+	if params.After != "" || params.OrderBy == "createdAt" && idColumn != "" {
+		if idColumn == "" {
+			return query, fmt.Errorf("After can't be used on this list!")
+		}
+		if params.OrderBy != "" && params.OrderBy != "createdAt" {
+			return query, fmt.Errorf("After can only be used when sorting by createdAt!")
+		}
+
+		if params.After != "" {
+			if query, err = afterCursor(query, params.After, direction, dateColumn, idColumn); err != nil {
+				return query, err
+			}
+		}
+		query = keysetOrder(query, direction, dateColumn, idColumn)
+	} else if params.OrderBy != "" {
+		column, ok := allowedSortCols[params.OrderBy]
+		if !ok {
+			allowed := make([]string, 0, len(allowedSortCols))
+			for name := range allowedSortCols {
+				allowed = append(allowed, name)
+			}
+			sort.Strings(allowed)
+
+			return query, fmt.Errorf("OrderBy must be one of %s!", strings.Join(allowed, ", "))
+		}
+
+		query = query.OrderBy(column + " " + direction + " NULLS LAST")
+	}
+
+	pageSize := pageSizeFor(route)
+	limit := pageSize.Limit(params.Limit)
+
+	// SQLite doesn't allow an offset without a limit.
+	if limit == 0 && params.Offset > 0 {
+		limit = pageSize.Max
+		if limit == 0 {
+			limit = math.MaxInt64
+		}
+	}
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if params.Offset > 0 {
+		query = query.Offset(params.Offset)
+	}
+
+	return query, nil
+}
+
+// rangeHeaderPattern matches Range headers of list requests, like
+// "items=0-49", which asks for the first 50 entries.
+var rangeHeaderPattern = regexp.MustCompile(`^items=(\d+)-(\d+)$`)
+
+// errRangeNotSatisfiable is returned by applyRangeHeader for ranges that
+// start past the largest offset that can be requested.
+var errRangeNotSatisfiable = errors.New("Range can't start past the largest offset that can be requested! To get further results send after with the createdAt and id of the last entry received.")
+
+// applyRangeHeader sets limit and offset of list params from the Range
+// header, for clients that paginate with it instead of the query. Limit and
+// offset sent in the query take precedence, and ranges in other units than
+// items are ignored. It returns true if the range was applied.
+func applyRangeHeader(ctx *gin.Context, params *ListParams) (bool, error) {
+	header := strings.TrimSpace(ctx.GetHeader("Range"))
+	if !strings.HasPrefix(header, "items=") {
+		return false, nil
+	}
+
+	query := ctx.Request.URL.Query()
+	if _, hasLimit := query["limit"]; hasLimit {
+		return false, nil
+	}
+	if _, hasOffset := query["offset"]; hasOffset {
+		return false, nil
+	}
+
+	match := rangeHeaderPattern.FindStringSubmatch(header)
+	if match == nil {
+		return false, fmt.Errorf("Range must be in items=first-last format, like items=0-49!")
+	}
+
+	first, err := strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return false, err
+	}
+	last, err := strconv.ParseUint(match[2], 10, 64)
+	if err != nil {
+		return false, err
+	}
+	if last < first {
+		return false, fmt.Errorf("Last entry of the range can't be before its first entry!")
+	}
+	if first > maxListOffset {
+		return false, errRangeNotSatisfiable
+	}
+
+	params.Offset = first
+	params.Limit = last - first + 1
+	return true, nil
+}
+
+// rangeStatus sets the Content-Range header of a list response to a Range
+// request that starts at offset and got count entries, and returns the
+// status it should be sent with. The total is only known when X-Total-Count
+// was set. A range that starts past the last entry isn't satisfiable.
+func rangeStatus(ctx *gin.Context, offset uint64, count int) int {
+	total := ctx.Writer.Header().Get("X-Total-Count")
+	if total == "" {
+		total = "*"
+	}
+
+	if count == 0 {
+		if offset > 0 {
+			ctx.Header("Content-Range", "items */"+total)
+			return http.StatusRequestedRangeNotSatisfiable
+		}
+		return http.StatusOK
+	}
+
+	ctx.Header("Content-Range", fmt.Sprintf("items %d-%d/%s", offset, offset+uint64(count)-1, total))
+	return http.StatusPartialContent
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	// Image formats that can be uploaded
+	_ "image/png"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// maxPhotoSize is the maximum size of an uploaded location photo in bytes.
+const maxPhotoSize = 5 << 20
+
+// maxPhotoDimension is the maximum width and height of an uploaded location
+// photo in pixels.
+const maxPhotoDimension = 8000
+
+// maxPhotoPixels is the maximum number of pixels of an uploaded location
+// photo. Decoding allocates memory for every pixel, so a small file claiming
+// to be huge isn't decoded at all.
+const maxPhotoPixels = 40000000
+
+// thumbnailSize is the maximum width and height of a generated thumbnail.
+const thumbnailSize = 256
+
+// photoExtensions maps allowed photo content types to file extensions they
+// are stored with.
+var photoExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png": ".png",
+}
+
+// LocationPhotosGetQuery : Structure that should be used for getting query data on get request for location photos
+type LocationPhotosGetQuery struct {
+	Thumbnail bool `form:"thumbnail"`
+}
+
+// LocationPhoto : Structure that should be used for getting location photo information from database
+type LocationPhoto struct {
+	ID int `db:"id"`
+	FileName string `db:"file_name"`
+	ThumbnailFileName string `db:"thumbnail_file_name"`
+	ContentType string `db:"content_type"`
+}
+
+// generateThumbnail scales the image down so it fits in a size by size square
+// keeping its aspect ratio. Images that already fit are returned as they are.
+func generateThumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+
+	thumbnailWidth, thumbnailHeight := size, size
+	if width > height {
+		thumbnailHeight = height * size / width
+	} else {
+		thumbnailWidth = width * size / height
+	}
+	if thumbnailWidth == 0 {
+		thumbnailWidth = 1
+	}
+	if thumbnailHeight == 0 {
+		thumbnailHeight = 1
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, thumbnailWidth, thumbnailHeight))
+	for y := 0; y < thumbnailHeight; y++ {
+		for x := 0; x < thumbnailWidth; x++ {
+			thumbnail.Set(x, y, img.At(bounds.Min.X+x*width/thumbnailWidth, bounds.Min.Y+y*height/thumbnailHeight))
+		}
+	}
+
+	return thumbnail
+}
+
+// photoRemovalTimeout is the time removing the files of photos deleted by a
+// single request can take.
+const photoRemovalTimeout = time.Minute
+
+// photoRemovals tracks files of location photos that are still being removed.
+var photoRemovals sync.WaitGroup
+
+// removeLocationPhotoFiles removes the files of location photos from the
+// attachment store in the background, so responses don't wait for the store.
+// It's not tied to the request, so files of photos that were already deleted
+// from the database are removed even if the client goes away. Files that
+// can't be removed are logged, since nothing refers to them anymore.
+func removeLocationPhotoFiles(store AttachmentStore, photos []LocationPhoto) {
+	if len(photos) == 0 {
+		return
+	}
+
+	photoRemovals.Add(1)
+	go func () {
+		defer photoRemovals.Done()
+
+		removalCtx, cancel := context.WithTimeout(context.Background(), photoRemovalTimeout)
+		defer cancel()
+
+		for _, photo := range photos {
+			for _, key := range []string{photo.FileName, photo.ThumbnailFileName} {
+				if err := store.Delete(removalCtx, key); err != nil {
+					log.Printf("Couldn't remove location photo file %s: %s", key, err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// deleteLocationPhotos deletes photo rows of a location inside a transaction
+// and returns them, so their files can be removed once it's committed.
+func deleteLocationPhotos(tx *sqlx.Tx, locationID int) ([]LocationPhoto, error) {
+	photosQueryString, photosQueryStringArgs, err := sq.Select("id, file_name, thumbnail_file_name, content_type").From("location_photos").Where(sq.Eq{"location_id": locationID}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	photos := []LocationPhoto{}
+	if err := tx.Select(&photos, photosQueryString, photosQueryStringArgs...); err != nil {
+		return nil, err
+	}
+
+	deleteQueryString, deleteQueryStringArgs, err := sq.Delete("location_photos").Where(sq.Eq{"location_id": locationID}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(deleteQueryString, deleteQueryStringArgs...); err != nil {
+		return nil, err
+	}
+
+	return photos, nil
+}
+
+// userOwnedLocationID gets the database entry id of a location with specified
+// public id if it's owned by the user. Responds with an error and returns
+// false if it's not.
+func userOwnedLocationID(ctx *gin.Context, db *DB, publicID string, userID int) (StructID, bool) {
+	userOwnsQuery := sq.Select("id").From("locations").Where(sq.Eq{"public_id": publicID, "created_by": userID})
+
+	userOwnsQueryString, userOwnsQueryStringArgs, err := userOwnsQuery.ToSql()
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return StructID{}, false
+	}
+
+	var location StructID
+	if err := db.Reader().GetContext(ctx.Request.Context(), &location, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			ctx.String(http.StatusUnauthorized, "Not authorized to access specified location.")
+			break
+		default:
+			ctx.String(http.StatusInternalServerError, err.Error())
+		}
+		return StructID{}, false
+	}
+
+	return location, true
+}
+
+// PostLocationPhotoHandler is a Gin handler function for uploading a photo of
+// a location. Uploading a new photo replaces the previous one.
+func PostLocationPhotoHandler(db *DB, g IDGenerator, store AttachmentStore) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		location, locationOwned := userOwnedLocationID(ctx, db, ctx.Param("id"), user.ID)
+		if !locationOwned {
+			return
+		}
+
+		// Leave some room for the rest of the multipart body.
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxPhotoSize+1<<20)
+
+		fileHeader, err := ctx.FormFile("photo")
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if fileHeader.Size > maxPhotoSize {
+			ctx.String(http.StatusRequestEntityTooLarge, "Photo can't be larger than 5 MB!")
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		defer file.Close()
+
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		contentType := http.DetectContentType(data)
+		extension, contentTypeAllowed := photoExtensions[contentType]
+		if !contentTypeAllowed {
+			ctx.String(http.StatusUnsupportedMediaType, "Photo must be a JPEG or PNG image!")
+			return
+		}
+
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "Photo is not a valid image!")
+			return
+		}
+
+		if config.Width > maxPhotoDimension || config.Height > maxPhotoDimension || config.Width*config.Height > maxPhotoPixels {
+			ctx.String(http.StatusRequestEntityTooLarge, "Photo can't be wider or taller than 8000 pixels or have more than 40 megapixels!")
+			return
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "Photo is not a valid image!")
+			return
+		}
+
+		var thumbnail bytes.Buffer
+		if err := jpeg.Encode(&thumbnail, generateThumbnail(img, thumbnailSize), nil); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		uuid, err := g.NewID()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		photo := LocationPhoto{
+			FileName: uuid + extension,
+			ThumbnailFileName: uuid + "_thumbnail.jpg",
+			ContentType: contentType,
+		}
+
+		if err := store.Put(ctx.Request.Context(), photo.FileName, data, photo.ContentType); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := store.Put(ctx.Request.Context(), photo.ThumbnailFileName, thumbnail.Bytes(), "image/jpeg"); err != nil {
+			removeLocationPhotoFiles(store, []LocationPhoto{photo})
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		query := sq.Insert("location_photos").Columns("location_id", "public_id", "file_name", "thumbnail_file_name", "content_type", "size", "created_at").Values(location.ID, uuid, photo.FileName, photo.ThumbnailFileName, photo.ContentType, len(data), time.Now().UTC())
+
+		queryString, queryStringArgs, err := query.ToSql()
+		if err != nil {
+			removeLocationPhotoFiles(store, []LocationPhoto{photo})
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var replacedPhotos []LocationPhoto
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			var err error
+			replacedPhotos, err = deleteLocationPhotos(tx, location.ID)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			removeLocationPhotoFiles(store, []LocationPhoto{photo})
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		removeLocationPhotoFiles(store, replacedPhotos)
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// GetLocationPhotoHandler is a Gin handler function for getting the photo of a
+// location or its thumbnail.
+func GetLocationPhotoHandler(db *DB, store AttachmentStore) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var photoQuery LocationPhotosGetQuery
+		if err := ctx.ShouldBindQuery(&photoQuery); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		query := sq.Select("location_photos.id, file_name, thumbnail_file_name, content_type").From("location_photos").Join("locations ON locations.id = location_photos.location_id").Where(sq.Eq{"locations.public_id": ctx.Param("id"), "locations.created_by": user.ID})
+
+		queryString, queryStringArgs, err := query.ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var photo LocationPhoto
+		if err := db.Reader().GetContext(ctx.Request.Context(), &photo, queryString, queryStringArgs...); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				ctx.String(http.StatusNotFound, "Location has no photo.")
+				break
+			default:
+				ctx.String(http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		key, contentType := photo.FileName, photo.ContentType
+		if photoQuery.Thumbnail {
+			key, contentType = photo.ThumbnailFileName, "image/jpeg"
+		}
+
+		file, size, err := store.Get(ctx.Request.Context(), key)
+		if err != nil {
+			switch err {
+			case ErrAttachmentNotFound:
+				ctx.String(http.StatusNotFound, "Location has no photo.")
+				break
+			default:
+				ctx.String(http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		defer file.Close()
+
+		// Files on the local disk can be served with range and conditional
+		// request support.
+		if local, ok := file.(*os.File); ok {
+			info, err := local.Stat()
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			ctx.Header("Content-Type", contentType)
+			http.ServeContent(ctx.Writer, ctx.Request, "", info.ModTime(), local)
+			return
+		}
+
+		ctx.DataFromReader(http.StatusOK, size, contentType, file, nil)
+	}
+}
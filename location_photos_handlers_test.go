@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPNG encodes a small PNG and then changes the size in its header to
+// width by height, so it claims to be larger than it is.
+func testPNG(t *testing.T, width uint32, height uint32) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	// The IHDR chunk follows the 8 byte signature, its data starts with the
+	// width and height and is followed by a CRC of its type and data.
+	binary.BigEndian.PutUint32(data[16:20], width)
+	binary.BigEndian.PutUint32(data[20:24], height)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+
+	return data
+}
+
+// newTestPhotoStore creates a local attachment store in a temporary
+// directory, which is removed once the test is done.
+func newTestPhotoStore(t *testing.T) LocalAttachmentStore {
+	t.Helper()
+
+	photosPath, err := ioutil.TempDir("", "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func () {
+		photoRemovals.Wait()
+		os.RemoveAll(photosPath)
+	})
+
+	return LocalAttachmentStore{Path: photosPath}
+}
+
+// uploadTestPhoto uploads data to the store as the photo of the location with
+// specified public id and returns the recorded response.
+func uploadTestPhoto(t *testing.T, db *DB, store AttachmentStore, publicID string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	router := newTestRouter("u1")
+	router.POST("/locations/photo/:id", PostLocationPhotoHandler(db, NanoidGenerator{}, store))
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("photo", "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	request := httptest.NewRequest("POST", "/locations/photo/"+publicID, &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestPostLocationPhotoDimensions(t *testing.T) {
+	db := newTestDB(t)
+	db.Writer().MustExec("insert into locations (public_id, name, address, created_by) values ('shop', 'Corner Shop', '1 Main Street', 1)")
+
+	for _, test := range []struct {
+		width uint32
+		height uint32
+		status int
+	}{
+		{50000, 50000, http.StatusRequestEntityTooLarge},
+		{8001, 1, http.StatusRequestEntityTooLarge},
+		{7000, 7000, http.StatusRequestEntityTooLarge},
+		{1, 1, http.StatusNoContent},
+	} {
+		recorder := uploadTestPhoto(t, db, newTestPhotoStore(t), "shop", testPNG(t, test.width, test.height))
+		if recorder.Code != test.status {
+			t.Fatalf("expected %d for %dx%d photo, got %d: %s", test.status, test.width, test.height, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestGetLocationPhotoRange(t *testing.T) {
+	db := newTestDB(t)
+	db.Writer().MustExec("insert into locations (public_id, name, address, created_by) values ('shop', 'Corner Shop', '1 Main Street', 1)")
+
+	store := newTestPhotoStore(t)
+	photo := testPNG(t, 1, 1)
+	if recorder := uploadTestPhoto(t, db, store, "shop", photo); recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	router := newTestRouter("u1")
+	router.GET("/locations/photo/:id", GetLocationPhotoHandler(db, store))
+
+	request := httptest.NewRequest("GET", "/locations/photo/shop", nil)
+	request.Header.Set("Range", "bytes=0-3")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusPartialContent || !bytes.Equal(recorder.Body.Bytes(), photo[:4]) {
+		t.Fatalf("expected 206 with the first 4 bytes, got %d: %q", recorder.Code, recorder.Body.Bytes())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Fatalf("expected image/png, got %q", contentType)
+	}
+
+	request = httptest.NewRequest("GET", "/locations/photo/shop", nil)
+	request.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", recorder.Code)
+	}
+}
+
+func TestPostLocationPhotoRemovesReplacedFiles(t *testing.T) {
+	db := newTestDB(t)
+	db.Writer().MustExec("insert into locations (public_id, name, address, created_by) values ('shop', 'Corner Shop', '1 Main Street', 1)")
+
+	store := newTestPhotoStore(t)
+	for i := 0; i < 2; i++ {
+		if recorder := uploadTestPhoto(t, db, store, "shop", testPNG(t, 1, 1)); recorder.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	photoRemovals.Wait()
+
+	files, err := ioutil.ReadDir(store.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected only the photo and thumbnail of the last upload, got %d files", len(files))
+	}
+}
+
+// failingAttachmentStore : Attachment store that can't remove any file
+type failingAttachmentStore struct {
+	LocalAttachmentStore
+}
+
+func (store failingAttachmentStore) Delete(requestCtx context.Context, key string) error {
+	return errors.New("storage is unavailable")
+}
+
+func TestRemoveLocationPhotoFilesLogsFailures(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	removeLocationPhotoFiles(failingAttachmentStore{}, []LocationPhoto{{FileName: "photo.png", ThumbnailFileName: "photo_thumbnail.jpg"}})
+	photoRemovals.Wait()
+
+	for _, key := range []string{"photo.png", "photo_thumbnail.jpg"} {
+		if !strings.Contains(logged.String(), key+": storage is unavailable") {
+			t.Fatalf("expected the failure to remove %s to be logged, got %s", key, logged.String())
+		}
+	}
+}
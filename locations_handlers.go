@@ -1,35 +1,136 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
-	"github.com/jkomyno/nanoid"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // LocationsGetQuery : Structure that should be used for getting query data on get request for locations
 type LocationsGetQuery struct {
+	ListParams
+	PublicID string `form:"id"`
 	Name string `form:"name"`
+	Country string `form:"country"`
+	Favorite *bool `form:"favorite"`
+	FavoritesFirst bool `form:"favoritesFirst"`
+	Active *bool `form:"active"`
+	Category string `form:"category"`
 }
 
 // LocationsPostBody : Structure that should be used for getting json from body of a post request for locations
 type LocationsPostBody struct {
-	Name string `json:"name" validate:"required"`
-	Address string `json:"address" validate:"required"`
+	Name string `json:"name" validate:"required,max=200"`
+	Address string `json:"address" validate:"required_without=Street,max=512"`
+	Street string `json:"street" validate:"omitempty,max=200"`
+	City string `json:"city" validate:"omitempty,max=100"`
+	PostalCode string `json:"postalCode" validate:"omitempty,max=20"`
+	Country string `json:"country" validate:"omitempty,iso3166_1_alpha2"`
+	Region string `json:"region" validate:"omitempty,max=100"`
+	Category string `json:"category" validate:"omitempty,location_category"`
+	Metadata json.RawMessage `json:"metadata" validate:"omitempty,max=4096,json_object"`
+}
+
+// fullAddress returns the freeform address, or composes one from the address
+// components if it wasn't sent.
+func (locationData LocationsPostBody) fullAddress() string {
+	if locationData.Address != "" {
+		return locationData.Address
+	}
+
+	cityLine := strings.TrimSpace(locationData.PostalCode + " " + locationData.City)
+
+	parts := []string{}
+	for _, part := range []string{locationData.Street, cityLine, locationData.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// firstNonEmpty returns the first of the values that isn't empty.
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// normalizeLocationName gets the key location names are compared by, so
+// names that only differ in casing or surrounding whitespace are considered
+// the same. The name is still stored and returned as it was sent.
+func normalizeLocationName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// suggestName fills in the name of a new location from its address with the
+// name suggester if it was sent without one. Name is still required if
+// nothing is suggested for the address.
+func (locationData *LocationsPostBody) suggestName(s NameSuggester) {
+	if locationData.Name == "" {
+		locationData.Name = s.SuggestName(locationData.fullAddress())
+	}
+}
+
+// takenLocationNames gets which of the names the user already has locations
+// with, as a set of normalized names. Names are compared the same way the
+// unique indexes of locations compare them, by their normalized name, or by
+// the name itself for locations that have no normalized name, so a name is
+// taken exactly when adding a location with it would fail.
+func takenLocationNames(requestCtx context.Context, q sqlx.QueryerContext, userID int, names []string) (map[string]bool, error) {
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		normalized = append(normalized, normalizeLocationName(name))
+	}
+
+	queryString, queryStringArgs, err := sq.Select("name").From("locations").Where(sq.Eq{"created_by": userID}).Where(sq.Or{sq.Eq{"name_normalized": normalized}, sq.Eq{"name": names}}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	taken := []string{}
+	if err := sqlx.SelectContext(requestCtx, q, &taken, queryString, queryStringArgs...); err != nil {
+		return nil, err
+	}
+
+	takenNames := map[string]bool{}
+	for _, name := range taken {
+		takenNames[normalizeLocationName(name)] = true
+	}
+
+	return takenNames, nil
 }
 
 // LocationsPutBody : Structure that should be used for getting json from body of a put request for locations
 type LocationsPutBody struct {
 	PublicID string `json:"id" validate:"required"`
-	Name string `json:"name"`
-	Address string `json:"address"`
+	Name string `json:"name" validate:"omitempty,max=200"`
+	Address string `json:"address" validate:"omitempty,max=512"`
+	Street string `json:"street" validate:"omitempty,max=200"`
+	City string `json:"city" validate:"omitempty,max=100"`
+	PostalCode string `json:"postalCode" validate:"omitempty,max=20"`
+	Country string `json:"country" validate:"omitempty,iso3166_1_alpha2"`
+	Region string `json:"region" validate:"omitempty,max=100"`
+	Category string `json:"category" validate:"omitempty,location_category"`
+	Metadata json.RawMessage `json:"metadata" validate:"omitempty,max=4096,json_object"`
 }
 
 // LocationsDeleteBody : Structure that should be used for getting json data from body of a delete request for locations
@@ -42,16 +143,76 @@ type Location struct {
 	PublicID string `db:"public_id" json:"id"`
 	Name string `db:"name" json:"name"`
 	Address string `db:"address" json:"address"`
+	NormalizedAddress string `db:"normalized_address" json:"normalizedAddress"`
+	Street *string `db:"street" json:"street"`
+	City *string `db:"city" json:"city"`
+	PostalCode *string `db:"postal_code" json:"postalCode"`
+	Country *string `db:"country" json:"country"`
+	Region *string `db:"region" json:"region"`
+	Category *string `db:"category" json:"category"`
+	IsDefault bool `db:"is_default" json:"isDefault"`
+	Favorite bool `db:"favorite" json:"favorite"`
+	Active bool `db:"active" json:"active"`
+	Metadata LocationMetadata `db:"metadata" json:"metadata"`
+	VisitCount int `db:"visit_count" json:"visitCount"`
+	SortOrder *int `db:"sort_order" json:"sortOrder"`
 	CreatedAt time.Time `db:"created_at" json:"createdAt"`
 	UpdatedAt time.Time `db:"updated_at" json:"updatedAt"`
+	Links *LocationLinks `db:"-" json:"_links,omitempty"`
+}
+
+// LocationMetadata : Structure that holds custom fields of a location as a JSON object, stored and returned as it was sent
+type LocationMetadata []byte
+
+// Scan copies the metadata JSON from a database column.
+func (metadata *LocationMetadata) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case string:
+		*metadata = LocationMetadata(value)
+	case []byte:
+		*metadata = append(LocationMetadata{}, value...)
+	case nil:
+		*metadata = nil
+	default:
+		return fmt.Errorf("can't scan %T into location metadata", src)
+	}
+
+	return nil
+}
+
+// MarshalJSON returns the metadata as it is, or an empty object if there is
+// none.
+func (metadata LocationMetadata) MarshalJSON() ([]byte, error) {
+	if len(metadata) == 0 {
+		return []byte("{}"), nil
+	}
+
+	return metadata, nil
+}
+
+// locationColumns are the columns that should be selected for scanning into
+// the Location structure.
+const locationColumns = "public_id, name, address, normalized_address, street, city, postal_code, country, region, category, is_default, favorite, active, metadata, visit_count, sort_order, created_at, updated_at"
+
+// locationSortColumns are the columns locations list can be sorted by.
+var locationSortColumns = map[string]string{
+	"name": "name",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+	"visitCount": "visit_count",
+	"manual": "sort_order",
+	"lastReceiptAt": "(SELECT MAX(receipts.created_at) FROM receipts WHERE receipts.location_id = locations.id)",
 }
 
+// metadataKeyPattern matches metadata keys that can be used in filters.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
 // GetLocationHandler is a Gin handler function for getting locations.
-func GetLocationHandler(db *sqlx.DB) gin.HandlerFunc {
+func GetLocationHandler(db *DB) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -61,13 +222,85 @@ func GetLocationHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		// Streamed responses start before the number of entries is known, so
+		// they can't have a Content-Range.
+		ranged := false
+		if !WantsNDJSON(ctx) {
+			var err error
+			ranged, err = applyRangeHeader(ctx, &searchQuery.ListParams)
+			if err == errRangeNotSatisfiable {
+				ctx.Header("Content-Range", "items */*")
+				ctx.String(http.StatusRequestedRangeNotSatisfiable, err.Error())
+				return
+			}
+			if err != nil {
+				ctx.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			ctx.Header("Accept-Ranges", "items")
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
-		query := sq.Select("public_id, name, address, created_at, updated_at").From("locations").Where(sq.Eq{"created_by": user.ID})
+		query := sq.Select(locationColumns).From("locations").Where(sq.Eq{"created_by": user.ID})
 
+		if searchQuery.PublicID != "" {
+			query = query.Where(sq.Eq{"public_id": searchQuery.PublicID})
+		}
 		if searchQuery.Name != "" {
 			query = query.Where("name LIKE ?", fmt.Sprint("%", searchQuery.Name, "%"))
 		}
+		if searchQuery.Country != "" {
+			query = query.Where(sq.Eq{"country": strings.ToUpper(searchQuery.Country)})
+		}
+		if searchQuery.Category != "" {
+			query = query.Where(sq.Eq{"category": searchQuery.Category})
+		}
+		if searchQuery.Favorite != nil {
+			query = query.Where(sq.Eq{"favorite": *searchQuery.Favorite})
+		}
+		if searchQuery.Active != nil {
+			query = query.Where(sq.Eq{"active": *searchQuery.Active})
+		}
+		for key, values := range ctx.Request.URL.Query() {
+			if !strings.HasPrefix(key, "metadata.") {
+				continue
+			}
+
+			if !db.HasJSONFunctions() {
+				ctx.String(http.StatusNotImplemented, "Filtering by metadata isn't available, the backend has to be built with the sqlite_json tag!")
+				return
+			}
+
+			metadataKey := strings.TrimPrefix(key, "metadata.")
+			if !metadataKeyPattern.MatchString(metadataKey) {
+				ctx.String(http.StatusBadRequest, "Metadata key can only contain letters, digits and underscores!")
+				return
+			}
+
+			// Values are compared as text so numbers and strings can be
+			// filtered the same way.
+			query = query.Where("CAST(json_extract(metadata, ?) AS TEXT) = ?", "$."+metadataKey, values[0])
+		}
+		if searchQuery.FavoritesFirst {
+			// Favorites would come first on every page, before the cursor.
+			if searchQuery.After != "" {
+				ctx.String(http.StatusBadRequest, "After can't be used together with favoritesFirst!")
+				return
+			}
+			query = query.OrderBy("favorite DESC")
+		}
+
+		query, err := buildListQuery(query, searchQuery.ListParams, ctx.FullPath(), locationSortColumns, "created_at", "public_id")
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if searchQuery.OrderBy == "manual" {
+			// Locations added since the last reorder have no sort order, so
+			// they're sorted last in the order they were created.
+			query = query.OrderBy("id")
+		}
 
 		queryString, queryStringArgs, err := query.ToSql()
 		if err != nil {
@@ -75,22 +308,62 @@ func GetLocationHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
+		if err := SetCountHeaders(ctx, db.Reader(), query, user.ID); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		tz := RequestTimezone(ctx)
+
+		if WantsNDJSON(ctx) {
+			rows, err := db.Reader().QueryxContext(ctx.Request.Context(), queryString, queryStringArgs...)
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			StreamNDJSON(ctx, rows, func () interface{} { return &Location{} }, func (row interface{}) {
+				row.(*Location).localize(tz)
+				row.(*Location).addLinks()
+			})
+			return
+		}
+
 		locations := []Location{}
-		if err := db.Select(&locations, queryString, queryStringArgs...); err != nil {
+		if err := db.Reader().SelectContext(ctx.Request.Context(), &locations, queryString, queryStringArgs...); err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		ctx.JSON(http.StatusOK, locations)
+		for i := range locations {
+			locations[i].localize(tz)
+			locations[i].addLinks()
+		}
+
+		status := http.StatusOK
+		if ranged {
+			status = rangeStatus(ctx, searchQuery.Offset, len(locations))
+			if status == http.StatusRequestedRangeNotSatisfiable {
+				ctx.String(status, "Range starts past the last location!")
+				return
+			}
+		}
+
+		if WantsProtobuf(ctx) {
+			ctx.ProtoBuf(status, locationListMessage(locations))
+			return
+		}
+
+		ListResponse(ctx, status, locations, queryString, queryStringArgs)
 	}
 }
 
 // PostLocationHandler is a Gin handler function for adding new locations.
-func PostLocationHandler(db *sqlx.DB) gin.HandlerFunc {
+func PostLocationHandler(db *DB, v *validator.Validate, n AddressNormalizer, s NameSuggester, g IDGenerator) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -100,48 +373,86 @@ func PostLocationHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		locationData.suggestName(s)
 
-		uuid, err := nanoid.Nanoid()
+		err := v.Struct(locationData)
 		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+			ctx.String(http.StatusBadRequest, err.Error())
 			return
 		}
 
-		query := sq.Insert("locations").Columns("public_id", "name", "address", "created_by").Values(uuid, locationData.Name, locationData.Address, user.ID)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
-		queryString, queryStringArgs, err := query.ToSql()
+		// The unique indexes still catch names taken by concurrent requests,
+		// this only gives the usual case a clearer message.
+		taken, err := takenLocationNames(ctx.Request.Context(), db.Reader(), user.ID, []string{locationData.Name})
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
+		if taken[normalizeLocationName(locationData.Name)] {
+			ctx.String(http.StatusConflict, "Location with the same name already exists!")
+			return
+		}
 
-		tx, err := db.Begin()
+		uuid, err := g.NewID()
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
+		metadata := "{}"
+		if len(locationData.Metadata) > 0 {
+			metadata = string(locationData.Metadata)
+		}
+
+		address := locationData.fullAddress()
+
+		// Timestamps are set explicitly so they don't depend on schema defaults.
+		now := time.Now().UTC()
+
+		query := sq.Insert("locations").Columns("public_id", "name", "name_normalized", "address", "normalized_address", "street", "city", "postal_code", "country", "region", "category", "metadata", "created_by", "created_at", "updated_at").Values(uuid, locationData.Name, normalizeLocationName(locationData.Name), address, n.Normalize(address), nullString(locationData.Street), nullString(locationData.City), nullString(locationData.PostalCode), nullString(locationData.Country), nullString(locationData.Region), nullString(locationData.Category), metadata, user.ID, now, now)
+
+		queryString, queryStringArgs, err := query.ToSql()
+		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		if err := tx.Commit(); err != nil {
+		locationQueryString, locationQueryStringArgs, err := sq.Select(locationColumns).From("locations").Where(sq.Eq{"public_id": uuid}).ToSql()
+		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		ctx.Status(http.StatusOK)
+		var location Location
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
+				return err
+			}
+
+			return tx.Get(&location, locationQueryString, locationQueryStringArgs...)
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		location.localize(RequestTimezone(ctx))
+		location.addLinks()
+		ctx.Header("ETag", entityETag(location.PublicID, location.UpdatedAt))
+		ctx.JSON(http.StatusCreated, location)
 	}
 }
 
 // PutLocationHandler is a Gin handler function for updating a location.
-func PutLocationHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+// Address components that aren't sent keep their stored values, and unless
+// the address is sent too it's made of the components again when any of
+// them changes.
+func PutLocationHandler(db *DB, v *validator.Validate, n AddressNormalizer) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -151,13 +462,22 @@ func PutLocationHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
+		if !bindPathID(ctx, &locationData.PublicID) {
+			return
+		}
+
+		ifMatch, ifMatchOk := ifMatchHeader(ctx)
+		if !ifMatchOk {
+			return
+		}
+
 		err := v.Struct(locationData)
 		if err != nil {
 			ctx.String(http.StatusBadRequest, err.Error())
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		userOwnsQuery := sq.Select("id").From("locations").Where(sq.Eq{"public_id": locationData.PublicID, "created_by": user.ID})
 
@@ -168,7 +488,7 @@ func PutLocationHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 		}
 
 		var location StructID
-		if err := db.Get(&location, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
+		if err := db.Reader().GetContext(ctx.Request.Context(), &location, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
 			switch err {
 			case sql.ErrNoRows:
 				ctx.String(http.StatusUnauthorized, "Not authrized to delete specified item from receipt.")
@@ -182,51 +502,119 @@ func PutLocationHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 		query := sq.Update("locations")
 
 		if locationData.Name != "" {
-			query = query.Set("name", locationData.Name)
+			query = query.Set("name", locationData.Name).Set("name_normalized", normalizeLocationName(locationData.Name))
 		}
 		if locationData.Address != "" {
-			query = query.Set("address", locationData.Address)
+			query = query.Set("address", locationData.Address).Set("normalized_address", n.Normalize(locationData.Address))
+		}
+		if locationData.Street != "" {
+			query = query.Set("street", locationData.Street)
+		}
+		if locationData.City != "" {
+			query = query.Set("city", locationData.City)
+		}
+		if locationData.PostalCode != "" {
+			query = query.Set("postal_code", locationData.PostalCode)
+		}
+		if locationData.Country != "" {
+			query = query.Set("country", locationData.Country)
+		}
+		if locationData.Region != "" {
+			query = query.Set("region", locationData.Region)
+		}
+		if locationData.Category != "" {
+			query = query.Set("category", locationData.Category)
+		}
+		if len(locationData.Metadata) > 0 {
+			query = query.Set("metadata", string(locationData.Metadata))
 		}
 
-		query = query.Set("updated_at", time.Now())
+		now := time.Now().UTC()
+		query = query.Set("updated_at", now)
 
-		queryString, queryStringArgs, err := query.Where(sq.Eq{"public_id": locationData.PublicID}).ToSql()
+		componentsQueryString, componentsQueryStringArgs, err := sq.Select("street, city, postal_code, country").From("locations").Where(sq.Eq{"id": location.ID}).ToSql()
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
+		componentsChanged := locationData.Street != "" || locationData.City != "" || locationData.PostalCode != "" || locationData.Country != ""
 
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+		var invalidPostalCode string
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if err := checkIfMatch(tx, "locations", locationData.PublicID, ifMatch); err != nil {
+				return err
+			}
+
+			update := query
+			if componentsChanged {
+				var stored struct {
+					Street sql.NullString `db:"street"`
+					City sql.NullString `db:"city"`
+					PostalCode sql.NullString `db:"postal_code"`
+					Country sql.NullString `db:"country"`
+				}
+				if err := tx.Get(&stored, componentsQueryString, componentsQueryStringArgs...); err != nil {
+					return err
+				}
+
+				// Components that weren't sent keep their stored values, so
+				// the postal code is checked against the country the
+				// location ends up in and the address describes all of them.
+				merged := LocationsPostBody{
+					Street: firstNonEmpty(locationData.Street, stored.Street.String),
+					City: firstNonEmpty(locationData.City, stored.City.String),
+					PostalCode: firstNonEmpty(locationData.PostalCode, stored.PostalCode.String),
+					Country: firstNonEmpty(locationData.Country, stored.Country.String),
+				}
+				if !validPostalCode(merged.PostalCode, merged.Country) {
+					invalidPostalCode = merged.PostalCode + " isn't a postal code in the format used in " + strings.ToUpper(merged.Country) + "!"
+					return nil
+				}
+
+				if locationData.Address == "" {
+					address := merged.fullAddress()
+					update = update.Set("address", address).Set("normalized_address", n.Normalize(address))
+				}
+			}
+
+			queryString, queryStringArgs, err := update.Where(sq.Eq{"public_id": locationData.PublicID}).ToSql()
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		if err := tx.Commit(); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+		if invalidPostalCode != "" {
+			ctx.String(http.StatusBadRequest, invalidPostalCode)
 			return
 		}
 
-		ctx.Status(http.StatusOK)
+		ctx.Header("ETag", entityETag(locationData.PublicID, now))
+		ctx.Status(http.StatusNoContent)
 	}
 }
 
 // DeleteLocationHandler is a Gin handler function for deleting a location.
-func DeleteLocationHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+func DeleteLocationHandler(db *DB, v *validator.Validate, store AttachmentStore) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
+		// Some proxies drop bodies of DELETE requests, so the id in the path
+		// is used when it's there and the body isn't read at all.
 		var locationData LocationsDeleteBody
-		if err := ctx.ShouldBindJSON(&locationData); err != nil {
+		if pathID := ctx.Param("id"); pathID != "" {
+			locationData.PublicID = pathID
+		} else if err := ctx.ShouldBindJSON(&locationData); err != nil {
 			ctx.String(http.StatusBadRequest, err.Error())
 			return
 		}
@@ -237,7 +625,7 @@ func DeleteLocationHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		userOwnsQuery := sq.Select("id").From("locations").Where(sq.Eq{"public_id": locationData.PublicID, "created_by": user.ID})
 
@@ -248,7 +636,7 @@ func DeleteLocationHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 		}
 
 		var location StructID
-		if err := db.Get(&location, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
+		if err := db.Reader().GetContext(ctx.Request.Context(), &location, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
 			ctx.String(http.StatusUnauthorized, "Not authrized to delete specified location.")
 			return
 		}
@@ -260,22 +648,1418 @@ func DeleteLocationHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		tx, err := db.Begin()
+		var photos []LocationPhoto
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			var err error
+			photos, err = deleteLocationPhotos(tx, location.ID)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		removeLocationPhotoFiles(store, photos)
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// GetDefaultLocationHandler is a Gin handler function for getting the default
+// location of a user.
+func GetDefaultLocationHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		query := sq.Select(locationColumns).From("locations").Where(sq.Eq{"created_by": user.ID, "is_default": true})
+
+		queryString, queryStringArgs, err := query.ToSql()
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
+		var location Location
+		if err := db.Reader().GetContext(ctx.Request.Context(), &location, queryString, queryStringArgs...); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				ctx.String(http.StatusNotFound, "No default location set.")
+				break
+			default:
+				ctx.String(http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		location.localize(RequestTimezone(ctx))
+		location.addLinks()
+		ctx.Header("ETag", entityETag(location.PublicID, location.UpdatedAt))
+		ctx.JSON(http.StatusOK, location)
+	}
+}
+
+// SetDefaultLocationHandler is a Gin handler function for marking a location
+// as the default one of a user. Previous default location is cleared in the
+// same transaction so there is always at most one default per user.
+func SetDefaultLocationHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		locationPublicID := ctx.Param("id")
+		if locationPublicID == "" {
+			ctx.String(http.StatusBadRequest, "Location id must be specified!")
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		userOwnsQuery := sq.Select("id").From("locations").Where(sq.Eq{"public_id": locationPublicID, "created_by": user.ID})
+
+		userOwnsQueryString, userOwnsQueryStringArgs, err := userOwnsQuery.ToSql()
+		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		if err := tx.Commit(); err != nil {
+		var location StructID
+		if err := db.Reader().GetContext(ctx.Request.Context(), &location, userOwnsQueryString, userOwnsQueryStringArgs...); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				ctx.String(http.StatusUnauthorized, "Not authorized to set specified location as default.")
+				break
+			default:
+				ctx.String(http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		clearQueryString, clearQueryStringArgs, err := sq.Update("locations").Set("is_default", false).Where(sq.Eq{"created_by": user.ID, "is_default": true}).ToSql()
+		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		ctx.Status(http.StatusOK)
+		setQueryString, setQueryStringArgs, err := sq.Update("locations").Set("is_default", true).Where(sq.Eq{"id": location.ID}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if _, err := tx.Exec(clearQueryString, clearQueryStringArgs...); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(setQueryString, setQueryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// ExportLocationsHandler is a Gin handler function for exporting all locations
+// of a user. Locations are streamed straight from the database cursor so the
+// memory usage stays flat no matter how many locations there are.
+func ExportLocationsHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		query := sq.Select(locationColumns).From("locations").Where(sq.Eq{"created_by": user.ID}).OrderBy("id")
+
+		queryString, queryStringArgs, err := query.ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		rows, err := db.Reader().QueryxContext(ctx.Request.Context(), queryString, queryStringArgs...)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		tz := RequestTimezone(ctx)
+		newRow := func () interface{} { return &Location{} }
+		prepare := func (row interface{}) {
+			row.(*Location).localize(tz)
+		}
+
+		if WantsNDJSON(ctx) {
+			ctx.Header("Content-Disposition", `attachment; filename="locations.ndjson"`)
+			StreamNDJSON(ctx, rows, newRow, prepare)
+			return
+		}
+
+		ctx.Header("Content-Disposition", `attachment; filename="locations.json"`)
+		StreamJSONArray(ctx, rows, newRow, prepare)
+	}
+}
+
+// LocationsFullExportQuery : Structure that should be used for getting query data on get request for full location export
+type LocationsFullExportQuery struct {
+	Format string `form:"format"`
+}
+
+// ExportedReceipt : Structure that is used for receipts nested in locations of a full export
+type ExportedReceipt struct {
+	PublicID string `json:"id"`
+	TotalPrice *float64 `json:"totalPrice"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ExportedLocation : Structure that is used for locations of a full export
+type ExportedLocation struct {
+	Location
+	Receipts []ExportedReceipt `json:"receipts"`
+}
+
+// locationWithReceiptRow : Structure that should be used for getting a location joined with one of its receipts from database
+type locationWithReceiptRow struct {
+	ID int `db:"id"`
+	Location
+	ReceiptPublicID *string `db:"receipt_public_id"`
+	ReceiptTotalPrice *float64 `db:"receipt_total_price"`
+	ReceiptCreatedAt *time.Time `db:"receipt_created_at"`
+	ReceiptUpdatedAt *time.Time `db:"receipt_updated_at"`
+}
+
+// queryLocationsFullExport gets the locations of a user joined with their
+// receipts, ordered by location so writeLocationsFullExport can nest the
+// receipts while it reads them.
+func queryLocationsFullExport(requestCtx context.Context, db *DB, userID int) (*sqlx.Rows, error) {
+	columns := []string{"locations.id"}
+	for _, column := range strings.Split(locationColumns, ", ") {
+		columns = append(columns, "locations."+column)
+	}
+	columns = append(columns, "receipts.public_id AS receipt_public_id", "(SELECT SUM(items.price * items_in_receipt.amount) FROM items_in_receipt JOIN items ON items.id = items_in_receipt.item_id WHERE items_in_receipt.receipt_id = receipts.id) AS receipt_total_price", "receipts.created_at AS receipt_created_at", "receipts.updated_at AS receipt_updated_at")
+
+	query := sq.Select(strings.Join(columns, ", ")).From("locations").LeftJoin("receipts ON receipts.location_id = locations.id").Where(sq.Eq{"locations.created_by": userID}).OrderBy("locations.id", "receipts.id")
+
+	queryString, queryStringArgs, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return db.Reader().QueryxContext(requestCtx, queryString, queryStringArgs...)
+}
+
+// writeLocationsFullExport writes rows from queryLocationsFullExport as a JSON
+// array of locations with their receipts nested in them. Only the receipts of
+// the location that is currently being written are held in memory. flush, if
+// it's not nil, is called every streamFlushInterval locations and at the end.
+func writeLocationsFullExport(w io.Writer, rows *sqlx.Rows, tz *time.Location, flush func ()) error {
+	defer rows.Close()
+
+	encoder := json.NewEncoder(w)
+
+	count := 0
+	var current *ExportedLocation
+	currentID := 0
+	writeCurrent := func () error {
+		if current == nil {
+			return nil
+		}
+
+		separator := "["
+		if count > 0 {
+			separator = ","
+		}
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+
+		current.localize(tz)
+		if err := encoder.Encode(current); err != nil {
+			return err
+		}
+
+		count++
+		if flush != nil && count%streamFlushInterval == 0 {
+			flush()
+		}
+		return nil
+	}
+
+	for rows.Next() {
+		var row locationWithReceiptRow
+		if err := rows.StructScan(&row); err != nil {
+			return err
+		}
+
+		if current == nil || row.ID != currentID {
+			if err := writeCurrent(); err != nil {
+				return err
+			}
+
+			current = &ExportedLocation{Location: row.Location, Receipts: []ExportedReceipt{}}
+			currentID = row.ID
+		}
+
+		if row.ReceiptPublicID != nil {
+			receipt := ExportedReceipt{PublicID: *row.ReceiptPublicID, TotalPrice: row.ReceiptTotalPrice}
+			if row.ReceiptCreatedAt != nil {
+				receipt.CreatedAt = row.ReceiptCreatedAt.In(tz)
+			}
+			if row.ReceiptUpdatedAt != nil {
+				receipt.UpdatedAt = row.ReceiptUpdatedAt.In(tz)
+			}
+			current.Receipts = append(current.Receipts, receipt)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := writeCurrent(); err != nil {
+		return err
+	}
+
+	end := "]"
+	if count == 0 {
+		end = "[]"
+	}
+	if _, err := io.WriteString(w, end); err != nil {
+		return err
+	}
+
+	if flush != nil {
+		flush()
+	}
+	return nil
+}
+
+// ExportLocationsFullHandler is a Gin handler function for exporting all
+// locations of a user with their receipts nested in them. Locations joined
+// with receipts are read from a single cursor ordered by location, so only
+// the receipts of the location that is currently being written are held in
+// memory.
+func ExportLocationsFullHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var exportQuery LocationsFullExportQuery
+		if err := ctx.ShouldBindQuery(&exportQuery); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if exportQuery.Format != "" && exportQuery.Format != "json" {
+			ctx.String(http.StatusBadRequest, "Format must be json!")
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		rows, err := queryLocationsFullExport(ctx.Request.Context(), db, user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.Header("Content-Disposition", `attachment; filename="locations-full.json"`)
+		ctx.Header("Content-Type", "application/json; charset=utf-8")
+		ctx.Status(http.StatusOK)
+
+		// Once the first byte is written the status can't be changed anymore,
+		// so errors that happen during streaming abort the response.
+		if err := writeLocationsFullExport(ctx.Writer, rows, RequestTimezone(ctx), ctx.Writer.Flush); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+		}
+	}
+}
+
+// LocationsStatsQuery : Structure that should be used for getting query data on get request for location statistics
+type LocationsStatsQuery struct {
+	Granularity string `form:"granularity"`
+	From string `form:"from"`
+	To string `form:"to"`
+}
+
+// LocationsStat : Structure that should be used for getting location creation statistics from database
+type LocationsStat struct {
+	Period string `db:"period" json:"period"`
+	Count int `db:"count" json:"count"`
+}
+
+// statsGranularity describes how created_at is bucketed for a specific
+// granularity and how many most recent buckets can be returned at most.
+type statsGranularity struct {
+	format string
+	maxBuckets uint64
+}
+
+// statsGranularities are the allowed granularities for location statistics.
+var statsGranularities = map[string]statsGranularity{
+	"day": {"%Y-%m-%d", 366},
+	"week": {"%Y-W%W", 260},
+	"month": {"%Y-%m", 120},
+}
+
+// GetLocationsStatsHandler is a Gin handler function for getting the number of
+// locations created per day, week, or month.
+func GetLocationsStatsHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var statsQuery LocationsStatsQuery
+		if err := ctx.ShouldBindQuery(&statsQuery); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if statsQuery.Granularity == "" {
+			statsQuery.Granularity = "month"
+		}
+
+		granularity, granularityExists := statsGranularities[statsQuery.Granularity]
+		if !granularityExists {
+			ctx.String(http.StatusBadRequest, "Granularity must be one of day, week, or month!")
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		query := sq.Select("strftime('" + granularity.format + "', created_at) AS period, COUNT(*) AS count").From("locations").Where(sq.Eq{"created_by": user.ID})
+
+		query, err := dateRange(query, statsQuery.From, statsQuery.To, "created_at")
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		query = query.GroupBy("period").OrderBy("period DESC").Limit(granularity.maxBuckets)
+
+		queryString, queryStringArgs, err := query.ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		stats := []LocationsStat{}
+		if err := db.Reader().SelectContext(ctx.Request.Context(), &stats, queryString, queryStringArgs...); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Most recent buckets are selected, but they are returned oldest first.
+		for i, j := 0, len(stats)-1; i < j; i, j = i+1, j-1 {
+			stats[i], stats[j] = stats[j], stats[i]
+		}
+
+		ctx.JSON(http.StatusOK, stats)
+	}
+}
+
+// LocationsSuggestQuery : Structure that should be used for getting query data on get request for location suggestions
+type LocationsSuggestQuery struct {
+	Prefix string `form:"prefix"`
+	Limit uint64 `form:"limit"`
+}
+
+// LocationSuggestion : Structure that should be used for getting location suggestions from database
+type LocationSuggestion struct {
+	PublicID string `db:"public_id" json:"id"`
+	Name string `db:"name" json:"name"`
+}
+
+// GetLocationsSuggestHandler is a Gin handler function for getting location
+// names starting with a prefix. It's meant to be called on every keystroke,
+// so it only does an indexed prefix lookup and returns a handful of results,
+// most used and most recently used locations first.
+func GetLocationsSuggestHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var suggestQuery LocationsSuggestQuery
+		if err := ctx.ShouldBindQuery(&suggestQuery); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		prefix := strings.ToLower(strings.TrimSpace(suggestQuery.Prefix))
+		if prefix == "" {
+			ctx.String(http.StatusBadRequest, "Prefix must be specified!")
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		// Range on lower(name) instead of LIKE so the lower(name) index is used.
+		query := sq.Select("locations.public_id, locations.name").From("locations").LeftJoin("receipts ON receipts.location_id = locations.id").Where(sq.Eq{"locations.created_by": user.ID}).Where("lower(locations.name) >= ? AND lower(locations.name) < ?", prefix, prefix+"\U0010FFFF").GroupBy("locations.id").OrderBy("COUNT(receipts.id) DESC", "MAX(receipts.created_at) DESC", "locations.updated_at DESC")
+		if limit := pageSizeFor(ctx.FullPath()).Limit(suggestQuery.Limit); limit > 0 {
+			query = query.Limit(limit)
+		}
+
+		queryString, queryStringArgs, err := query.ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		suggestions := []LocationSuggestion{}
+		if err := db.Reader().SelectContext(ctx.Request.Context(), &suggestions, queryString, queryStringArgs...); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ListResponse(ctx, http.StatusOK, suggestions, queryString, queryStringArgs)
+	}
+}
+
+// maxBatchSize is the maximum number of ids that can be sent in one batch
+// request.
+const maxBatchSize = 100
+
+// maxIDListSize is the maximum number of ids that can be sent to requests
+// that change many entries at once, like reordering locations or moving
+// receipts. Limited so the ids never go over the SQLite host parameter limit.
+const maxIDListSize = 500
+
+// LocationsBatchGetBody : Structure that should be used for getting json from body of a batch get request for locations
+type LocationsBatchGetBody struct {
+	IDs []string `json:"ids"`
+}
+
+// LocationsBatchGetResponse : Structure that is used as a response of a batch get request for locations
+type LocationsBatchGetResponse struct {
+	Locations []Location `json:"locations"`
+	Missing []string `json:"missing"`
+}
+
+// BatchGetLocationsHandler is a Gin handler function for getting multiple
+// locations by their ids in one request. Ids which don't exist or aren't
+// owned by the user are returned as missing.
+func BatchGetLocationsHandler(db *DB, v *validator.Validate) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var batchData LocationsBatchGetBody
+		if err := ctx.ShouldBindJSON(&batchData); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !requireBatch(ctx, "ids", len(batchData.IDs), maxBatchSize) {
+			return
+		}
+
+		batchData.IDs = uniqueStrings(batchData.IDs)
+
+		err := v.Struct(batchData)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		query := sq.Select(locationColumns).From("locations").Where(sq.Eq{"public_id": batchData.IDs, "created_by": user.ID})
+
+		queryString, queryStringArgs, err := query.ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response := LocationsBatchGetResponse{Locations: []Location{}, Missing: []string{}}
+		if err := db.Reader().SelectContext(ctx.Request.Context(), &response.Locations, queryString, queryStringArgs...); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		tz := RequestTimezone(ctx)
+		found := map[string]bool{}
+		for i := range response.Locations {
+			response.Locations[i].localize(tz)
+			found[response.Locations[i].PublicID] = true
+		}
+		for _, id := range batchData.IDs {
+			if !found[id] {
+				response.Missing = append(response.Missing, id)
+			}
+		}
+
+		ctx.JSON(http.StatusOK, response)
+	}
+}
+
+// maxCopyNames is the number of names that are tried for a copy of a location
+// before giving up.
+const maxCopyNames = 100
+
+// copyNames returns the names a copy of a location with specified name can
+// get, in the order they should be tried, like "Shop (copy)", "Shop (copy 2)"
+// and so on.
+func copyNames(name string) []string {
+	names := make([]string, 0, maxCopyNames)
+	names = append(names, name+" (copy)")
+	for i := 2; i <= maxCopyNames; i++ {
+		names = append(names, fmt.Sprintf("%s (copy %d)", name, i))
+	}
+
+	return names
+}
+
+// CloneLocationHandler is a Gin handler function for creating a copy of a
+// location. Name gets a " (copy)" suffix, or " (copy 2)" and so on if the
+// user already has a location with it, while timestamps, default flag and
+// receipts are not copied.
+func CloneLocationHandler(db *DB, g IDGenerator) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		source, sourceOwned := userOwnedLocationID(ctx, db, ctx.Param("id"), user.ID)
+		if !sourceOwned {
+			return
+		}
+
+		uuid, err := g.NewID()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		sourceQueryString, sourceQueryStringArgs, err := sq.Select(locationColumns).From("locations").Where(sq.Eq{"id": source.ID}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var location Location
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if err := tx.Get(&location, sourceQueryString, sourceQueryStringArgs...); err != nil {
+				return err
+			}
+
+			// The first copy name the user doesn't have yet is used. If all
+			// of them are taken the insert fails on the unique index.
+			names := copyNames(location.Name)
+			taken, err := takenLocationNames(ctx.Request.Context(), tx, user.ID, names)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now().UTC()
+			location.PublicID = uuid
+			location.Name = names[0]
+			for _, name := range names {
+				if !taken[normalizeLocationName(name)] {
+					location.Name = name
+					break
+				}
+			}
+			location.IsDefault = false
+			location.VisitCount = 0
+			location.SortOrder = nil
+			location.Active = true
+			location.CreatedAt = now
+			location.UpdatedAt = now
+
+			query := sq.Insert("locations").Columns("public_id", "name", "name_normalized", "address", "normalized_address", "street", "city", "postal_code", "country", "region", "category", "metadata", "created_by", "created_at", "updated_at").Values(location.PublicID, location.Name, normalizeLocationName(location.Name), location.Address, location.NormalizedAddress, location.Street, location.City, location.PostalCode, location.Country, location.Region, location.Category, string(location.Metadata), user.ID, location.CreatedAt, location.UpdatedAt)
+
+			queryString, queryStringArgs, err := query.ToSql()
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		location.localize(RequestTimezone(ctx))
+		location.addLinks()
+		ctx.Header("ETag", entityETag(location.PublicID, location.UpdatedAt))
+		ctx.JSON(http.StatusCreated, location)
+	}
+}
+
+// TouchLocationHandler is a Gin handler function for marking a location as
+// recently active by bumping its updated_at timestamp without changing any
+// other field.
+func TouchLocationHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		location, locationOwned := userOwnedLocationID(ctx, db, ctx.Param("id"), user.ID)
+		if !locationOwned {
+			return
+		}
+
+		touchQueryString, touchQueryStringArgs, err := sq.Update("locations").Set("updated_at", time.Now().UTC()).Where(sq.Eq{"id": location.ID}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		locationQueryString, locationQueryStringArgs, err := sq.Select(locationColumns).From("locations").Where(sq.Eq{"id": location.ID}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var touched Location
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if _, err := tx.Exec(touchQueryString, touchQueryStringArgs...); err != nil {
+				return err
+			}
+
+			return tx.Get(&touched, locationQueryString, locationQueryStringArgs...)
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		touched.localize(RequestTimezone(ctx))
+		touched.addLinks()
+		ctx.Header("ETag", entityETag(touched.PublicID, touched.UpdatedAt))
+		ctx.JSON(http.StatusOK, touched)
+	}
+}
+
+// ToggleFavoriteLocationHandler is a Gin handler function for starring a
+// location as a favorite, or unstarring it if it already is one.
+func ToggleFavoriteLocationHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		location, locationOwned := userOwnedLocationID(ctx, db, ctx.Param("id"), user.ID)
+		if !locationOwned {
+			return
+		}
+
+		toggleQueryString, toggleQueryStringArgs, err := sq.Update("locations").Set("favorite", sq.Expr("NOT favorite")).Set("updated_at", time.Now().UTC()).Where(sq.Eq{"id": location.ID}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		locationQueryString, locationQueryStringArgs, err := sq.Select(locationColumns).From("locations").Where(sq.Eq{"id": location.ID}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var toggled Location
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if _, err := tx.Exec(toggleQueryString, toggleQueryStringArgs...); err != nil {
+				return err
+			}
+
+			return tx.Get(&toggled, locationQueryString, locationQueryStringArgs...)
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		toggled.localize(RequestTimezone(ctx))
+		toggled.addLinks()
+		ctx.Header("ETag", entityETag(toggled.PublicID, toggled.UpdatedAt))
+		ctx.JSON(http.StatusOK, toggled)
+	}
+}
+
+// ToggleActiveLocationHandler is a Gin handler function for marking a location
+// as temporarily unavailable, or available again if it already is marked.
+// Unlike deleting, the location and its receipts are kept and it's still
+// listed.
+func ToggleActiveLocationHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		location, locationOwned := userOwnedLocationID(ctx, db, ctx.Param("id"), user.ID)
+		if !locationOwned {
+			return
+		}
+
+		toggleQueryString, toggleQueryStringArgs, err := sq.Update("locations").Set("active", sq.Expr("NOT active")).Set("updated_at", time.Now().UTC()).Where(sq.Eq{"id": location.ID}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		locationQueryString, locationQueryStringArgs, err := sq.Select(locationColumns).From("locations").Where(sq.Eq{"id": location.ID}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var toggled Location
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if _, err := tx.Exec(toggleQueryString, toggleQueryStringArgs...); err != nil {
+				return err
+			}
+
+			return tx.Get(&toggled, locationQueryString, locationQueryStringArgs...)
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		toggled.localize(RequestTimezone(ctx))
+		toggled.addLinks()
+		ctx.Header("ETag", entityETag(toggled.PublicID, toggled.UpdatedAt))
+		ctx.JSON(http.StatusOK, toggled)
+	}
+}
+
+// AddressValidationBody : Structure that should be used for getting json from body of a request for validating an address
+type AddressValidationBody struct {
+	Address string `json:"address" validate:"required"`
+	Country string `json:"country" validate:"omitempty,iso3166_1_alpha2"`
+}
+
+// AddressValidationResult : Structure that is used as a response of a request for validating an address
+type AddressValidationResult struct {
+	Valid bool `json:"valid"`
+	Errors []ValidationError `json:"errors"`
+	Warnings []string `json:"warnings"`
+	Normalized string `json:"normalized"`
+}
+
+// LocationValidationResult : Structure that is used as a response for a single location of a validate batch request
+type LocationValidationResult struct {
+	Index int `json:"index"`
+	Valid bool `json:"valid"`
+	Errors []ValidationError `json:"errors"`
+	NormalizedAddress string `json:"normalizedAddress"`
+	DuplicateOf *int `json:"duplicateOf"`
+	NameTaken bool `json:"nameTaken"`
+}
+
+// ValidateLocationsBatchHandler is a Gin handler function for checking a batch
+// of new locations without adding them. Every location gets its name
+// suggested, is validated and has its address normalized the same way as when
+// it's added, and locations whose name repeats an earlier one in the batch or
+// is already taken by another location of the user are marked, since their
+// creation would fail. Nothing is written to the database.
+func ValidateLocationsBatchHandler(db *DB, v *validator.Validate, n AddressNormalizer, s NameSuggester) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var locationsData []LocationsPostBody
+		if err := ctx.ShouldBindJSON(&locationsData); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !requireBatch(ctx, "the body", len(locationsData), maxBatchSize) {
+			return
+		}
+
+		results := []LocationValidationResult{}
+		names := []string{}
+		firstWithName := map[string]int{}
+		for i := range locationsData {
+			locationsData[i].suggestName(s)
+			locationData := locationsData[i]
+
+			result := LocationValidationResult{
+				Index: i,
+				Valid: true,
+				Errors: []ValidationError{},
+				NormalizedAddress: n.Normalize(locationData.fullAddress()),
+			}
+
+			if err := v.Struct(locationData); err != nil {
+				result.Valid = false
+				result.Errors = formatValidationErrors(err)
+			}
+
+			name := normalizeLocationName(locationData.Name)
+			if first, seen := firstWithName[name]; seen {
+				duplicateOf := first
+				result.DuplicateOf = &duplicateOf
+			} else if name != "" {
+				firstWithName[name] = i
+				names = append(names, locationData.Name)
+			}
+
+			results = append(results, result)
+		}
+
+		if len(names) > 0 {
+			user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+			takenNames, err := takenLocationNames(ctx.Request.Context(), db.Reader(), user.ID, names)
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			for i := range results {
+				results[i].NameTaken = takenNames[normalizeLocationName(locationsData[i].Name)]
+			}
+		}
+
+		ctx.JSON(http.StatusOK, results)
+	}
+}
+
+// ValidateAddressHandler is a Gin handler function for checking an address
+// while it's being entered, before any location is created with it. The
+// address is normalized the same way as when a location is added, and things
+// that look wrong but are still allowed are returned as warnings.
+func ValidateAddressHandler(v *validator.Validate, n AddressNormalizer) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		_, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var addressData AddressValidationBody
+		if err := ctx.ShouldBindJSON(&addressData); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result := AddressValidationResult{
+			Valid: true,
+			Errors: []ValidationError{},
+			Warnings: addressWarnings(addressData.Address, addressData.Country),
+			Normalized: n.Normalize(addressData.Address),
+		}
+
+		if err := v.Struct(addressData); err != nil {
+			result.Valid = false
+			result.Errors = formatValidationErrors(err)
+		}
+
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+// similarLocationThreshold is the minimum similarity score of locations
+// returned as similar.
+const similarLocationThreshold = 0.5
+
+// maxSimilarLocations is the maximum number of similar locations returned.
+const maxSimilarLocations = 10
+
+// SimilarLocation : Structure that is used as a response for a single location of a similar locations request
+type SimilarLocation struct {
+	Location
+	Score float64 `json:"score"`
+}
+
+// locationSimilarity scores how likely two locations are the same place by
+// averaging the similarity of their names and addresses. Normalized addresses
+// are compared when both locations have them.
+func locationSimilarity(a Location, b Location) float64 {
+	aAddress, bAddress := a.Address, b.Address
+	if a.NormalizedAddress != "" && b.NormalizedAddress != "" {
+		aAddress, bAddress = a.NormalizedAddress, b.NormalizedAddress
+	}
+
+	return (tokenSimilarity(a.Name, b.Name) + tokenSimilarity(aAddress, bAddress)) / 2
+}
+
+// GetSimilarLocationsHandler is a Gin handler function for getting other
+// locations of a user that look like duplicates of a location, most similar
+// first.
+func GetSimilarLocationsHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		source, sourceOwned := userOwnedLocationID(ctx, db, ctx.Param("id"), user.ID)
+		if !sourceOwned {
+			return
+		}
+
+		queryString, queryStringArgs, err := sq.Select("id, "+locationColumns).From("locations").Where(sq.Eq{"created_by": user.ID}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		rows := []struct {
+			ID int `db:"id"`
+			Location
+		}{}
+		if err := db.Reader().SelectContext(ctx.Request.Context(), &rows, queryString, queryStringArgs...); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var sourceLocation Location
+		for _, row := range rows {
+			if row.ID == source.ID {
+				sourceLocation = row.Location
+				break
+			}
+		}
+
+		similar := []SimilarLocation{}
+		for _, row := range rows {
+			if row.ID == source.ID {
+				continue
+			}
+
+			if score := locationSimilarity(sourceLocation, row.Location); score >= similarLocationThreshold {
+				similar = append(similar, SimilarLocation{Location: row.Location, Score: score})
+			}
+		}
+
+		sort.SliceStable(similar, func (i, j int) bool {
+			return similar[i].Score > similar[j].Score
+		})
+		if len(similar) > maxSimilarLocations {
+			similar = similar[:maxSimilarLocations]
+		}
+
+		tz := RequestTimezone(ctx)
+		for i := range similar {
+			similar[i].localize(tz)
+		}
+
+		ctx.JSON(http.StatusOK, similar)
+	}
+}
+
+// MoveReceiptsBody : Structure that should be used for getting json from body of a request for moving receipts between locations
+type MoveReceiptsBody struct {
+	ToLocationID string `json:"toLocationId" validate:"required"`
+	ReceiptIDs []string `json:"receiptIds"`
+}
+
+// MoveReceiptsHandler is a Gin handler function for moving receipts from the
+// location in the path to another location of the user, like when two shops
+// are merged. Receipts are moved in one transaction, so if any of them
+// doesn't belong to the location none of them are moved.
+func MoveReceiptsHandler(db *DB, v *validator.Validate) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var moveData MoveReceiptsBody
+		if err := ctx.ShouldBindJSON(&moveData); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !requireBatch(ctx, "receiptIds", len(moveData.ReceiptIDs), maxIDListSize) {
+			return
+		}
+
+		moveData.ReceiptIDs = uniqueStrings(moveData.ReceiptIDs)
+
+		err := v.Struct(moveData)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if moveData.ToLocationID == ctx.Param("id") {
+			ctx.String(http.StatusBadRequest, "Receipts can't be moved to the location they're already in!")
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		from, fromOwned := userOwnedLocationID(ctx, db, ctx.Param("id"), user.ID)
+		if !fromOwned {
+			return
+		}
+
+		to, toOwned := userOwnedLocationID(ctx, db, moveData.ToLocationID, user.ID)
+		if !toOwned {
+			return
+		}
+
+		var missing []string
+		var moved int64
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			owned, err := ownedIDs(ctx.Request.Context(), tx, "receipts", moveData.ReceiptIDs, user.ID, sq.Eq{"location_id": from.ID})
+			if err != nil {
+				return err
+			}
+
+			missing = missingIDs(moveData.ReceiptIDs, owned)
+			if len(missing) > 0 {
+				return nil
+			}
+
+			moveQueryString, moveQueryStringArgs, err := sq.Update("receipts").Set("location_id", to.ID).Set("updated_at", time.Now().UTC()).Where(sq.Eq{"id": ownedIDValues(owned)}).ToSql()
+			if err != nil {
+				return err
+			}
+
+			result, err := tx.Exec(moveQueryString, moveQueryStringArgs...)
+			if err != nil {
+				return err
+			}
+
+			moved, err = result.RowsAffected()
+			if err != nil {
+				return err
+			}
+
+			if err := addLocationVisits(tx, from.ID, -moved); err != nil {
+				return err
+			}
+			return addLocationVisits(tx, to.ID, moved)
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		if len(missing) > 0 {
+			ctx.String(http.StatusBadRequest, "Receipts "+strings.Join(missing, ", ")+" don't belong to the location!")
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"moved": moved})
+	}
+}
+
+// DuplicateAddressGroup : Structure that is used as a response entry of a request for locations sharing an address
+type DuplicateAddressGroup struct {
+	NormalizedAddress string `json:"normalizedAddress"`
+	Count int `json:"count"`
+	Locations []Location `json:"locations"`
+}
+
+// duplicateAddressRow : Structure that should be used for getting a location together with the address it's grouped by from database
+type duplicateAddressRow struct {
+	GroupAddress string `db:"group_address"`
+	Location
+}
+
+// duplicateAddressKey is the address locations are grouped by when looking
+// for duplicates. Locations saved before addresses were normalized fall back
+// to their address as it was sent.
+const duplicateAddressKey = "COALESCE(NULLIF(normalized_address, ''), address)"
+
+// GetDuplicateAddressesHandler is a Gin handler function for getting groups
+// of locations of a user that share the same normalized address, so they can
+// be merged.
+func GetDuplicateAddressesHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		duplicates := sq.Select(duplicateAddressKey).From("locations").Where(sq.Eq{"created_by": user.ID}).Where("trim(" + duplicateAddressKey + ") != ''").GroupBy(duplicateAddressKey).Having("COUNT(*) > 1")
+
+		duplicatesQueryString, duplicatesQueryStringArgs, err := duplicates.ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		queryString, queryStringArgs, err := sq.Select(duplicateAddressKey+" AS group_address, "+locationColumns).From("locations").Where(sq.Eq{"created_by": user.ID}).Where(duplicateAddressKey+" IN ("+duplicatesQueryString+")", duplicatesQueryStringArgs...).OrderBy("group_address", "id").ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		rows := []duplicateAddressRow{}
+		if err := db.Reader().SelectContext(ctx.Request.Context(), &rows, queryString, queryStringArgs...); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		tz := RequestTimezone(ctx)
+		groups := []DuplicateAddressGroup{}
+		for _, row := range rows {
+			if len(groups) == 0 || groups[len(groups)-1].NormalizedAddress != row.GroupAddress {
+				groups = append(groups, DuplicateAddressGroup{NormalizedAddress: row.GroupAddress, Locations: []Location{}})
+			}
+
+			group := &groups[len(groups)-1]
+			row.Location.localize(tz)
+			row.Location.addLinks()
+			group.Locations = append(group.Locations, row.Location)
+			group.Count++
+		}
+
+		ctx.JSON(http.StatusOK, groups)
+	}
+}
+
+// LocationsReorderBody : Structure that should be used for getting json from body of a request for reordering locations
+type LocationsReorderBody struct {
+	IDs []string `json:"ids"`
+}
+
+// ReorderLocationsHandler is a Gin handler function for setting the manual
+// order of locations, which the list uses when it's sorted by manual. Ids
+// are sent in the order locations should be in, and locations that aren't
+// sent keep their previous order after them. Sort orders of all locations of
+// the user are rewritten as 1, 2, 3 and so on in one transaction, so there
+// are never gaps or ties.
+func ReorderLocationsHandler(db *DB, v *validator.Validate) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var reorderData LocationsReorderBody
+		if err := ctx.ShouldBindJSON(&reorderData); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !requireBatch(ctx, "ids", len(reorderData.IDs), maxIDListSize) {
+			return
+		}
+
+		err := v.Struct(reorderData)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if len(uniqueStrings(reorderData.IDs)) != len(reorderData.IDs) {
+			ctx.String(http.StatusBadRequest, "Every location can be sent only once!")
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		// Current order, with locations that were never ordered after the
+		// rest in the order they were created, the same way the list sorts
+		// them.
+		currentQueryString, currentQueryStringArgs, err := sq.Select("id").From("locations").Where(sq.Eq{"created_by": user.ID}).OrderBy("sort_order ASC NULLS LAST", "id").ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var missing []string
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			owned, err := ownedIDs(ctx.Request.Context(), tx, "locations", reorderData.IDs, user.ID)
+			if err != nil {
+				return err
+			}
+
+			missing = missingIDs(reorderData.IDs, owned)
+			if len(missing) > 0 {
+				return nil
+			}
+
+			current := []int64{}
+			if err := tx.Select(&current, currentQueryString, currentQueryStringArgs...); err != nil {
+				return err
+			}
+
+			sent := map[int64]bool{}
+			order := []int64{}
+			for _, publicID := range reorderData.IDs {
+				sent[owned[publicID]] = true
+				order = append(order, owned[publicID])
+			}
+
+			for _, id := range current {
+				if !sent[id] {
+					order = append(order, id)
+				}
+			}
+
+			for i, id := range order {
+				queryString, queryStringArgs, err := sq.Update("locations").Set("sort_order", i+1).Where(sq.Eq{"id": id}).ToSql()
+				if err != nil {
+					return err
+				}
+
+				if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		if len(missing) > 0 {
+			ctx.String(http.StatusBadRequest, "Locations "+strings.Join(missing, ", ")+" don't exist or aren't owned by the user!")
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// clearableLocationFields maps location fields that can be cleared in bulk to
+// the values their columns are set to. Optional fields are set to NULL, while
+// address can't be NULL, so it's set to an empty string together with its
+// normalized form. Name can't be cleared since it's how locations are told
+// apart.
+var clearableLocationFields = map[string]map[string]interface{}{
+	"address": {"address": "", "normalized_address": ""},
+	"street": {"street": nil},
+	"city": {"city": nil},
+	"postalCode": {"postal_code": nil},
+	"country": {"country": nil},
+	"region": {"region": nil},
+	"category": {"category": nil},
+}
+
+// LocationsBulkClearBody : Structure that should be used for getting json from body of a request for clearing a field of multiple locations
+type LocationsBulkClearBody struct {
+	IDs []string `json:"ids"`
+	Field string `json:"field" validate:"required"`
+}
+
+// BulkClearLocationsHandler is a Gin handler function for clearing one of
+// the fields of multiple locations in one transaction. Ids that
+// don't exist or aren't owned by the user are skipped, and the number of
+// locations that were cleared is returned.
+func BulkClearLocationsHandler(db *DB, v *validator.Validate) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var clearData LocationsBulkClearBody
+		if err := ctx.ShouldBindJSON(&clearData); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !requireBatch(ctx, "ids", len(clearData.IDs), maxBatchSize) {
+			return
+		}
+
+		clearData.IDs = uniqueStrings(clearData.IDs)
+
+		err := v.Struct(clearData)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		columns, clearable := clearableLocationFields[clearData.Field]
+		if !clearable {
+			fields := make([]string, 0, len(clearableLocationFields))
+			for field := range clearableLocationFields {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+
+			ctx.String(http.StatusBadRequest, "Field must be one of "+strings.Join(fields, ", ")+"!")
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		var cleared int64
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			owned, err := ownedIDs(ctx.Request.Context(), tx, "locations", clearData.IDs, user.ID)
+			if err != nil || len(owned) == 0 {
+				return err
+			}
+
+			queryString, queryStringArgs, err := sq.Update("locations").SetMap(columns).Set("updated_at", time.Now().UTC()).Where(sq.Eq{"id": ownedIDValues(owned)}).ToSql()
+			if err != nil {
+				return err
+			}
+
+			result, err := tx.Exec(queryString, queryStringArgs...)
+			if err != nil {
+				return err
+			}
+
+			cleared, err = result.RowsAffected()
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"cleared": cleared})
 	}
 }
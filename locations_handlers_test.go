@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"testing"
+
+	"github.com/go-playground/validator"
+)
+
+// newTestLocationsRouter creates a router with the location routes tests
+// use, handling requests as the user with specified public id.
+func newTestLocationsRouter(t *testing.T, db *DB, userID string) http.Handler {
+	t.Helper()
+
+	v := validator.New()
+	if err := RegisterValidations(v); err != nil {
+		t.Fatal(err)
+	}
+
+	router := newTestRouter(userID)
+	router.GET("/locations", GetLocationHandler(db))
+	router.GET("/locations/stats", GetLocationsStatsHandler(db))
+	router.POST("/locations", PostLocationHandler(db, v, NoopAddressNormalizer{}, NoopNameSuggester{}, NanoidGenerator{}))
+	router.PUT("/locations", PutLocationHandler(db, v, NoopAddressNormalizer{}))
+	router.POST("/locations/batch-get", BatchGetLocationsHandler(db, v))
+	router.POST("/locations/bulk-clear", BulkClearLocationsHandler(db, v))
+	router.POST("/locations/reorder", ReorderLocationsHandler(db, v))
+	router.POST("/locations/move-receipts/:id", MoveReceiptsHandler(db, v))
+	router.POST("/locations/clone/:id", CloneLocationHandler(db, NanoidGenerator{}))
+	router.POST("/locations/validate-batch", ValidateLocationsBatchHandler(db, v, NoopAddressNormalizer{}, NoopNameSuggester{}))
+
+	return router
+}
+
+func TestGetLocationsAfterCursor(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+	db.Writer().MustExec(`insert into locations (public_id, name, address, created_by, created_at) values
+		('e', 'E', '1 Main Street', 1, '2020-05-01 10:00:01+00:00'),
+		('d', 'D', '1 Main Street', 1, '2020-05-01 10:00:00.5+00:00'),
+		('c', 'C', '1 Main Street', 1, '2020-05-01 10:00:00.5+00:00'),
+		('b', 'B', '1 Main Street', 1, '2020-05-01 10:00:00'),
+		('a', 'A', '1 Main Street', 1, '2020-05-01 10:00:00')`)
+
+	for _, test := range []struct {
+		order string
+		expected string
+	}{
+		{"asc", "a,b,c,d,e"},
+		{"desc", "e,d,c,b,a"},
+	} {
+		received := []string{}
+		after := ""
+		for page := 0; page < 5; page++ {
+			path := "/locations?limit=2&orderBy=createdAt&order=" + test.order
+			if after != "" {
+				path += "&after=" + url.QueryEscape(after)
+			}
+
+			recorder := serveJSON(router, "GET", path, "")
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			locations := []struct {
+				PublicID string `json:"id"`
+				CreatedAt time.Time `json:"createdAt"`
+			}{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &locations); err != nil {
+				t.Fatal(err)
+			}
+			if len(locations) == 0 {
+				break
+			}
+
+			for _, location := range locations {
+				received = append(received, location.PublicID)
+			}
+			last := locations[len(locations)-1]
+			after = last.CreatedAt.Format(time.RFC3339Nano) + "," + last.PublicID
+		}
+
+		if strings.Join(received, ",") != test.expected {
+			t.Fatalf("expected %s sorted %s, got %v", test.expected, test.order, received)
+		}
+	}
+
+	if recorder := serveJSON(router, "GET", "/locations?after=a&orderBy=name", ""); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a malformed cursor, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serveJSON(router, "GET", "/locations?after=2020-05-01T10:00:00Z,a&orderBy=name", ""); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a cursor sorted by name, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestGetLocationsRangePastOffsetLimit(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+
+	request := httptest.NewRequest("GET", "/locations", nil)
+	request.Header.Set("Range", fmt.Sprintf("items=%d-%d", maxListOffset+1, maxListOffset+10))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("expected 416, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if contentRange := recorder.Header().Get("Content-Range"); contentRange != "items */*" {
+		t.Fatalf("expected Content-Range items */*, got %q", contentRange)
+	}
+}
+
+func TestLocationDateFilters(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+	db.Writer().MustExec(`insert into locations (public_id, name, address, created_by, created_at) values
+		('before', 'Before', '1 Main Street', 1, '2020-04-30 23:59:59+00:00'),
+		('first', 'First', '1 Main Street', 1, '2020-05-01 00:00:00+00:00'),
+		('last', 'Last', '1 Main Street', 1, '2020-05-31 23:59:59.5+00:00'),
+		('after', 'After', '1 Main Street', 1, '2020-06-01 00:00:00+00:00')`)
+
+	// The same range written in another timezone.
+	query := "?from=" + url.QueryEscape("2020-05-01T02:00:00+02:00") + "&to=" + url.QueryEscape("2020-06-01T00:00:00Z")
+
+	recorder := serveJSON(router, "GET", "/locations"+query, "")
+	locations := []struct {
+		PublicID string `json:"id"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &locations); err != nil {
+		t.Fatalf("expected locations, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if len(locations) != 2 {
+		t.Fatalf("expected first and last to be listed, got %v", locations)
+	}
+
+	recorder = serveJSON(router, "GET", "/locations/stats"+query+"&granularity=month", "")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != `[{"period":"2020-05","count":2}]` {
+		t.Fatalf("expected the stats to count the same locations, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	for _, path := range []string{"/locations", "/locations/stats"} {
+		if recorder := serveJSON(router, "GET", path+"?from=yesterday", ""); recorder.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for a malformed from on %s, got %d: %s", path, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestPostLocationConcurrentSameName(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	statuses := make([]int, 2)
+	for i := range statuses {
+		wg.Add(1)
+		go func (i int) {
+			defer wg.Done()
+			<-start
+			statuses[i] = serveJSON(router, "POST", "/locations", `{"name": "Corner Shop", "address": "1 Main Street"}`).Code
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	created, conflicts := 0, 0
+	for _, status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+			conflicts++
+		}
+	}
+	if created != 1 || conflicts != 1 {
+		t.Fatalf("expected one 201 and one 409, got %v", statuses)
+	}
+
+	var count int
+	if err := db.Reader().Get(&count, "select count(*) from locations where name = 'Corner Shop'"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 location, got %d", count)
+	}
+}
+
+func TestPostLocationSameNameOtherUser(t *testing.T) {
+	db := newTestDB(t)
+
+	body := `{"name": "Corner Shop", "address": "1 Main Street"}`
+	if recorder := serveJSON(newTestLocationsRouter(t, db, "u1"), "POST", "/locations", body); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serveJSON(newTestLocationsRouter(t, db, "u2"), "POST", "/locations", body); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for another user, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serveJSON(newTestLocationsRouter(t, db, "u1"), "POST", "/locations", `{"name": "CORNER SHOP", "address": "1 Main Street"}`); recorder.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for the same name in other casing, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestValidateLocationsBatchNameTaken(t *testing.T) {
+	db := newTestDB(t)
+
+	if recorder := serveJSON(newTestLocationsRouter(t, db, "u1"), "POST", "/locations", `{"name": "Corner Shop", "address": "1 Main Street"}`); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	body := `[{"name": "corner shop", "address": "1 Main Street"}]`
+	for userID, expected := range map[string]bool{"u1": true, "u2": false} {
+		recorder := serveJSON(newTestLocationsRouter(t, db, userID), "POST", "/locations/validate-batch", body)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		results := []LocationValidationResult{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].NameTaken != expected {
+			t.Fatalf("expected nameTaken to be %v for %s, got %s", expected, userID, recorder.Body.String())
+		}
+	}
+}
+
+func TestPutLocationAddressComponents(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+
+	recorder := serveJSON(router, "POST", "/locations", `{"name": "Corner Shop", "street": "Hauptstrasse 1", "city": "Berlin", "postalCode": "10115", "country": "DE"}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var location struct {
+		PublicID string `json:"id"`
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &location); err != nil {
+		t.Fatal(err)
+	}
+
+	// The postal code is checked against the stored country.
+	recorder = serveJSON(router, "PUT", "/locations", `{"id": "`+location.PublicID+`", "postalCode": "1011"}`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a postal code that isn't used in DE, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serveJSON(router, "PUT", "/locations", `{"id": "`+location.PublicID+`", "city": "Munich", "postalCode": "80331"}`)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var stored struct {
+		Address string `db:"address"`
+		PostalCode string `db:"postal_code"`
+	}
+	if err := db.Reader().Get(&stored, "select address, postal_code from locations where public_id = ?", location.PublicID); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Address != "Hauptstrasse 1, 80331 Munich, DE" || stored.PostalCode != "80331" {
+		t.Fatalf("expected the address to be made of the updated components, got %+v", stored)
+	}
+}
+
+func TestLocationTimestamps(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+
+	recorder := serveJSON(router, "POST", "/locations", `{"name": "Corner Shop", "address": "1 Main Street"}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var location struct {
+		PublicID string `json:"id"`
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &location); err != nil {
+		t.Fatal(err)
+	}
+	if location.CreatedAt.IsZero() || location.UpdatedAt.IsZero() {
+		t.Fatalf("expected both timestamps to be set on create, got %s", recorder.Body.String())
+	}
+
+	// Move the timestamps back, so the update can be told apart.
+	past := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.Writer().MustExec("update locations set created_at = ?, updated_at = ? where public_id = ?", past, past, location.PublicID)
+
+	recorder = serveJSON(router, "PUT", "/locations", `{"id": "`+location.PublicID+`", "name": "Corner Shop 2"}`)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var updated struct {
+		CreatedAt time.Time `db:"created_at"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}
+	if err := db.Reader().Get(&updated, "select created_at, updated_at from locations where public_id = ?", location.PublicID); err != nil {
+		t.Fatal(err)
+	}
+	if !updated.CreatedAt.Equal(past) {
+		t.Fatalf("expected created_at to stay %v on update, got %v", past, updated.CreatedAt)
+	}
+	if !updated.UpdatedAt.After(past) {
+		t.Fatalf("expected updated_at to be set on update, got %v", updated.UpdatedAt)
+	}
+}
+
+func TestPostLocationNameTooLong(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+
+	recorder := serveJSON(router, "POST", "/locations", `{"name": "`+strings.Repeat("a", 201)+`", "address": "1 Main Street"}`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var count int
+	if err := db.Reader().Get(&count, "select count(*) from locations"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no location to be added, got %d", count)
+	}
+}
+
+func TestCloneLocationNames(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+	db.Writer().MustExec("insert into locations (public_id, name, name_normalized, address, created_by) values ('shop', 'Corner Shop', 'corner shop', '1 Main Street', 1)")
+
+	for _, expected := range []string{"Corner Shop (copy)", "Corner Shop (copy 2)", "Corner Shop (copy 3)"} {
+		recorder := serveJSON(router, "POST", "/locations/clone/shop", "")
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		var location struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &location); err != nil {
+			t.Fatal(err)
+		}
+		if location.Name != expected {
+			t.Fatalf("expected the copy to be named %q, got %q", expected, location.Name)
+		}
+	}
+}
+
+func TestBulkClearLocations(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+	db.Writer().MustExec("insert into locations (public_id, name, address, normalized_address, city, created_by) values ('first', 'First Shop', '1 Main Street', '1 MAIN ST', 'Springfield', 1), ('second', 'Second Shop', '2 Main Street', '2 MAIN ST', 'Springfield', 1), ('other', 'Other Shop', '3 Main Street', '3 MAIN ST', 'Springfield', 2)")
+
+	for _, field := range []string{"address", "city"} {
+		recorder := serveJSON(router, "POST", "/locations/bulk-clear", `{"ids": ["first", "second", "other"], "field": "`+field+`"}`)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != `{"cleared":2}` {
+			t.Fatalf("expected 200 with 2 cleared locations, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+
+	locations := []struct {
+		PublicID string `db:"public_id"`
+		Address string `db:"address"`
+		NormalizedAddress string `db:"normalized_address"`
+		City *string `db:"city"`
+	}{}
+	if err := db.Reader().Select(&locations, "select public_id, address, normalized_address, city from locations"); err != nil {
+		t.Fatal(err)
+	}
+	for _, location := range locations {
+		cleared := location.Address == "" && location.NormalizedAddress == "" && location.City == nil
+		if cleared != (location.PublicID != "other") {
+			t.Fatalf("expected only locations of the user to be cleared, got %+v", location)
+		}
+	}
+
+	if recorder := serveJSON(router, "POST", "/locations/bulk-clear", `{"ids": ["first"], "field": "name"}`); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for clearing the name, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestReorderLocations(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+	db.Writer().MustExec("insert into locations (public_id, name, address, created_by) values ('first', 'First Shop', '1 Main Street', 1), ('second', 'Second Shop', '2 Main Street', 1), ('third', 'Third Shop', '3 Main Street', 1), ('other', 'Other Shop', '4 Main Street', 2)")
+
+	if recorder := serveJSON(router, "POST", "/locations/reorder", `{"ids": ["third", "other"]}`); recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "other") {
+		t.Fatalf("expected 400 naming the location of the other user, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := serveJSON(router, "POST", "/locations/reorder", `{"ids": ["third", "first"]}`); recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	order := []string{}
+	if err := db.Reader().Select(&order, "select public_id from locations where created_by = 1 order by sort_order"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "third,first,second" {
+		t.Fatalf("expected third, first, second, got %v", order)
+	}
+}
+
+func TestMoveReceipts(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+	db.Writer().MustExec("insert into locations (id, public_id, name, address, created_by, visit_count) values (1, 'first', 'First Shop', '1 Main Street', 1, 2), (2, 'second', 'Second Shop', '2 Main Street', 1, 1)")
+	db.Writer().MustExec("insert into receipts (public_id, location_id, created_by) values ('a', 1, 1), ('b', 1, 1), ('c', 2, 1)")
+
+	if recorder := serveJSON(router, "POST", "/locations/move-receipts/first", `{"toLocationId": "second", "receiptIds": ["a", "c"]}`); recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "c") {
+		t.Fatalf("expected 400 naming the receipt of the other location, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := serveJSON(router, "POST", "/locations/move-receipts/first", `{"toLocationId": "second", "receiptIds": ["a", "b"]}`); recorder.Code != http.StatusOK || recorder.Body.String() != `{"moved":2}` {
+		t.Fatalf("expected 200 with 2 moved receipts, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if visits := locationVisits(t, db); visits["first"] != 0 || visits["second"] != 3 {
+		t.Fatalf("expected visits to move with the receipts, got %v", visits)
+	}
+}
+
+func TestBatchSizeLimits(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+
+	ids := make([]string, maxBatchSize+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id%d", i)
+	}
+	body, err := json.Marshal(map[string][]string{"ids": ids})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		body string
+		status int
+	}{
+		{`{"ids": []}`, http.StatusBadRequest},
+		{string(body), http.StatusBadRequest},
+		{`{"ids": ["id0"]}`, http.StatusOK},
+	} {
+		if recorder := serveJSON(router, "POST", "/locations/batch-get", test.body); recorder.Code != test.status {
+			t.Fatalf("expected %d, got %d: %s", test.status, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// +build !sqlite_json
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetLocationsMetadataFilterWithoutJSON(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+
+	if db.HasJSONFunctions() {
+		t.Skip("SQLite has JSON functions without the sqlite_json tag")
+	}
+
+	if recorder := serveJSON(router, "GET", "/locations?metadata.store=42", ""); recorder.Code != http.StatusNotImplemented {
+		t.Fatalf("expected 501, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,47 @@
+// +build sqlite_json
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGetLocationsMetadataFilter(t *testing.T) {
+	db := newTestDB(t)
+	router := newTestLocationsRouter(t, db, "u1")
+	db.Writer().MustExec(`insert into locations (public_id, name, address, metadata, created_by) values
+		('text', 'Text Shop', '1 Main Street', '{"store": "42"}', 1),
+		('number', 'Number Shop', '2 Main Street', '{"store": 42}', 1),
+		('other', 'Other Shop', '3 Main Street', '{"store": "7"}', 1),
+		('none', 'Plain Shop', '4 Main Street', '{}', 1),
+		('foreign', 'Foreign Shop', '5 Main Street', '{"store": "42"}', 2)`)
+
+	recorder := serveJSON(router, "GET", "/locations?metadata.store=42", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	locations := []struct {
+		PublicID string `json:"id"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &locations); err != nil {
+		t.Fatal(err)
+	}
+
+	found := []string{}
+	for _, location := range locations {
+		found = append(found, location.PublicID)
+	}
+	sort.Strings(found)
+	if strings.Join(found, ",") != "number,text" {
+		t.Fatalf("expected the user's locations with store 42 as text and number, got %v", found)
+	}
+
+	if recorder := serveJSON(router, "GET", "/locations?metadata.store']=1", ""); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a key that isn't allowed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
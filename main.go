@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	// Server related stuff
 
@@ -22,6 +27,10 @@ import (
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 
+	// Metrics stuff
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	// Other stuff
 	"github.com/go-playground/validator"
 	_ "github.com/joho/godotenv/autoload"
@@ -44,11 +53,171 @@ func PublicToPrivateUserID(db *sqlx.DB, PublicID string) (StructID) {
 	return user
 }
 
+// maxQueryParams is the maximum number of host parameters SQLite allows in a
+// single query by default.
+const maxQueryParams = 999
+
+// ownedIDs checks which of the specified public ids in a table belong to a
+// specific user. It returns a map of owned public ids to their database entry
+// ids, so callers can diff it against the requested ids to find the ones that
+// aren't owned or don't exist. Extra conditions can narrow down which entries
+// count as owned, like receipts in a specific location. Ids are checked in
+// chunks so the query never goes over the SQLite host parameter limit, no
+// matter how many are passed. q can be a transaction, so the ids can't change
+// owners before the caller is done with them.
+func ownedIDs(requestCtx context.Context, q sqlx.QueryerContext, table string, publicIDs []string, userID int, conditions ...sq.Sqlizer) (map[string]int64, error) {
+	owned := map[string]int64{}
+
+	// One parameter is taken by the user id, and the rest of them by the
+	// extra conditions.
+	chunkSize := maxQueryParams - 1
+	for _, condition := range conditions {
+		_, args, err := condition.ToSql()
+		if err != nil {
+			return nil, err
+		}
+		chunkSize -= len(args)
+	}
+	for start := 0; start < len(publicIDs); start += chunkSize {
+		end := start + chunkSize
+		if end > len(publicIDs) {
+			end = len(publicIDs)
+		}
+
+		if err := ownedIDsChunk(requestCtx, q, table, publicIDs[start:end], userID, conditions, owned); err != nil {
+			return nil, err
+		}
+	}
+
+	return owned, nil
+}
+
+// ownedIDsChunk adds owned public ids from a single chunk to owned.
+func ownedIDsChunk(requestCtx context.Context, q sqlx.QueryerContext, table string, publicIDs []string, userID int, conditions []sq.Sqlizer, owned map[string]int64) error {
+	query := sq.Select("id, public_id").From(table).Where(sq.Eq{"public_id": publicIDs, "created_by": userID})
+	for _, condition := range conditions {
+		query = query.Where(condition)
+	}
+
+	queryString, queryStringArgs, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	rows, err := q.QueryxContext(requestCtx, queryString, queryStringArgs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var publicID string
+		if err := rows.Scan(&id, &publicID); err != nil {
+			return err
+		}
+
+		owned[publicID] = id
+	}
+
+	return rows.Err()
+}
+
+// missingIDs returns the public ids that aren't in owned, in the order they
+// were sent.
+func missingIDs(publicIDs []string, owned map[string]int64) []string {
+	missing := []string{}
+	for _, id := range publicIDs {
+		if _, ok := owned[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	return missing
+}
+
+// ownedIDValues returns the database entry ids of owned.
+func ownedIDValues(owned map[string]int64) []int64 {
+	ids := make([]int64, 0, len(owned))
+	for _, id := range owned {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
 // GetUserID get the user id from specified context. It's literarly used just
 // so I can write one line instead of two.
 func GetUserID(ctx *gin.Context) (string, bool) {
 	userID, userIDExists := ctx.Get("userID")
-	return userID.(string), userIDExists
+	if !userIDExists {
+		return "", false
+	}
+
+	return userID.(string), true
+}
+
+// uniqueStrings returns the values without duplicates, keeping the order in
+// which they first appear.
+func uniqueStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, s := range values {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+
+	return unique
+}
+
+// requireBatch checks that a batch request has at least one and at most
+// maxSize entries, so every batch handler rejects empty and oversized batches
+// the same way instead of running queries with an empty IN list. It responds
+// with an error and returns false if the batch isn't allowed. name is the
+// part of the request the entries are sent in.
+func requireBatch(ctx *gin.Context, name string, size int, maxSize int) bool {
+	if size == 0 {
+		ctx.String(http.StatusBadRequest, "Batch can't be empty, "+name+" must have at least one entry!")
+		return false
+	}
+
+	if size > maxSize {
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("Batch can't have more than %d entries, %s has %d!", maxSize, name, size))
+		return false
+	}
+
+	return true
+}
+
+// bindPathID applies the id from the path of update routes like
+// /locations/:id to the id from the request body. The path id is used when
+// the body has none, and if both are set they have to be the same, otherwise
+// it responds with an error and returns false. Routes without an id in the
+// path leave the body id as it is.
+func bindPathID(ctx *gin.Context, bodyID *string) bool {
+	pathID := ctx.Param("id")
+	if pathID == "" {
+		return true
+	}
+
+	if *bodyID != "" && *bodyID != pathID {
+		ctx.String(http.StatusBadRequest, "Id in the body doesn't match the id in the path!")
+		return false
+	}
+
+	*bodyID = pathID
+	return true
+}
+
+// nullString converts an empty string to NULL for optional database columns.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
 
 func main() {
@@ -57,6 +226,17 @@ func main() {
 	corsConfig.AllowOrigins = strings.Split(os.Getenv("ALLOW_ORIGINS"), ",")
 	corsConfig.AllowCredentials = true
 	router.Use(cors.New(corsConfig))
+	router.Use(JSONContentTypeMiddleware("/locations/photo/:id"))
+	if os.Getenv("LOG_REQUESTS") == "true" {
+		router.Use(RequestLogMiddleware())
+	}
+	if os.Getenv("REQUIRE_USER_AGENT") == "true" {
+		router.Use(UserAgentMiddleware(os.Getenv("ALLOWED_USER_AGENTS")))
+	}
+
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(NotFoundHandler)
+	router.NoMethod(MethodNotAllowedHandler)
 
 	db, err := generateDatabase()
 	if err != nil {
@@ -69,6 +249,92 @@ func main() {
 	goth.UseProviders(google.New(os.Getenv("GOOGLE_OAUTH_CLIENT_KEY"), os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"), os.Getenv("GOOGLE_OAUTH_CALLBACK_URL")))
 
 	v := validator.New()
+	if categories := os.Getenv("LOCATION_CATEGORIES"); categories != "" {
+		locationCategories = parseLocationCategories(categories)
+	}
+
+	if err := RegisterValidations(v); err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	n, err := NewAddressNormalizer(os.Getenv("ADDRESS_NORMALIZER"))
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	s, err := NewNameSuggester(os.Getenv("NAME_SUGGESTER"))
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	nanoidLength := 0
+	if length := os.Getenv("NANOID_LENGTH"); length != "" {
+		nanoidLength, err = strconv.Atoi(length)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+	}
+
+	g, err := NewIDGenerator(os.Getenv("ID_GENERATOR"), os.Getenv("NANOID_ALPHABET"), nanoidLength)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	if maxOffset := os.Getenv("MAX_LIST_OFFSET"); maxOffset != "" {
+		maxListOffset, err = strconv.ParseUint(maxOffset, 10, 64)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+	}
+
+	if err := parsePageSizes(os.Getenv("PAGE_SIZES")); err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	requireIfMatch = os.Getenv("REQUIRE_IF_MATCH") == "true"
+
+	apiBasePath = os.Getenv("API_BASE_PATH")
+
+	if os.Getenv("DEBUG_SQL") == "true" {
+		debugSQLUsers = parseAdminUsers(os.Getenv("ADMIN_USERS"))
+	}
+
+	features := NewFeatures(os.Getenv("FEATURES"))
+
+	timeouts, err := NewRouteTimeouts(os.Getenv("ROUTE_TIMEOUTS"), os.Getenv("REQUEST_TIMEOUT"))
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	router.Use(TimeoutMiddleware(timeouts))
+
+	photosPath := os.Getenv("PHOTOS_PATH")
+	if photosPath == "" {
+		photosPath = "photos"
+	}
+
+	attachments, err := NewAttachmentStore(os.Getenv("ATTACHMENT_STORE"), photosPath, S3AttachmentStore{
+		Endpoint: os.Getenv("S3_ENDPOINT"),
+		Bucket: os.Getenv("S3_BUCKET"),
+		Region: os.Getenv("S3_REGION"),
+		AccessKeyID: os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+	})
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	exportsPath := os.Getenv("EXPORTS_PATH")
+	if exportsPath == "" {
+		exportsPath = "exports"
+	}
+
+	exportJobs, err := NewExportJobs(db, exportsPath, os.Getenv("EXPORT_JOB_TTL"))
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if err := exportJobs.Start(); err != nil {
+		log.Fatalln(err.Error())
+	}
 
 	auth := router.Group("/auth")
 	{
@@ -77,20 +343,98 @@ func main() {
 		auth.GET("/callback", AuthCallbackHandler(db))
 	}
 
+	// Get current server time
+	router.GET("/time", GetTimeHandler)
+
 	locations := router.Group("/locations")
 	locations.Use(TokenVerificationMiddleware(db))
 	{
 		// Get list of locations (query available)
 		locations.GET("", GetLocationHandler(db))
 
+		// Get location creation statistics
+		locations.GET("/stats", GetLocationsStatsHandler(db))
+
+		// Get location photo
+		locations.GET("/photo/:id", GetLocationPhotoHandler(db, attachments))
+
+		// Get location name suggestions for a prefix
+		locations.GET("/suggest", RateLimitMiddleware(10, time.Second), GetLocationsSuggestHandler(db))
+
+		// Export all locations
+		locations.GET("/export", requireFeature(features, "export"), ExportLocationsHandler(db))
+
+		// Export all locations with their receipts
+		locations.GET("/export/full", requireFeature(features, "export"), ExportLocationsFullHandler(db))
+
+		// Get status of a background export job
+		locations.GET("/export/jobs/:id", requireFeature(features, "export"), GetExportJobHandler(db))
+
+		// Download file of a finished background export job
+		locations.GET("/export/download/:id", requireFeature(features, "export"), DownloadExportJobHandler(db, exportJobs))
+
+		// Get default location
+		locations.GET("/default", GetDefaultLocationHandler(db))
+
+		// Get locations that look like duplicates of a location
+		locations.GET("/similar/:id", requireFeature(features, "similar"), GetSimilarLocationsHandler(db))
+
+		// Get groups of locations sharing the same address
+		locations.GET("/duplicate-addresses", GetDuplicateAddressesHandler(db))
+
 		// Add new location
-		locations.POST("", PostLocationHandler(db))
+		locations.POST("", PostLocationHandler(db, v, n, s, g))
+
+		// Start exporting all locations with their receipts in the background
+		locations.POST("/export/jobs", requireFeature(features, "export"), RateLimitMiddleware(5, time.Hour), PostExportJobHandler(db, g, exportJobs))
+
+		// Get multiple locations by their ids
+		locations.POST("/batch-get", BatchGetLocationsHandler(db, v))
+
+		// Validate multiple new locations without adding them
+		locations.POST("/validate-batch", requireFeature(features, "validate-batch"), ValidateLocationsBatchHandler(db, v, n, s))
+
+		// Validate and normalize an address without adding a location
+		locations.POST("/validate-address", ValidateAddressHandler(v, n))
+
+		// Create a copy of a location
+		locations.POST("/clone/:id", CloneLocationHandler(db, g))
+
+		// Upload location photo
+		locations.POST("/photo/:id", PostLocationPhotoHandler(db, g, attachments))
+
+		// Set location as default
+		locations.POST("/default/:id", SetDefaultLocationHandler(db))
+
+		// Bump updated_at of a location without changing it
+		locations.POST("/touch/:id", TouchLocationHandler(db))
+
+		// Star or unstar location as a favorite
+		locations.POST("/favorite/:id", ToggleFavoriteLocationHandler(db))
+
+		// Mark location as unavailable or available again
+		locations.POST("/active/:id", ToggleActiveLocationHandler(db))
+
+		// Move receipts of a location to another location
+		locations.POST("/move-receipts/:id", MoveReceiptsHandler(db, v))
+
+		// Set manual order of locations
+		locations.POST("/reorder", ReorderLocationsHandler(db, v))
+
+		// Clear an optional field of multiple locations
+		locations.POST("/bulk-clear", BulkClearLocationsHandler(db, v))
 
 		// Update location
-		locations.PUT("", PutLocationHandler(db, v))
+		locations.PUT("", PutLocationHandler(db, v, n))
+
+		// Update location with id in the path
+		locations.PUT("/:id", PutLocationHandler(db, v, n))
 
 		// Delete location
-		locations.DELETE("", DeleteLocationHandler(db, v))
+		locations.DELETE("", DeleteLocationHandler(db, v, attachments))
+
+		// Delete location with id in the path
+		locations.DELETE("/:id", DeleteLocationHandler(db, v, attachments))
 	}
 
 	items := router.Group("/items")
@@ -103,16 +447,16 @@ func main() {
 		items.GET("/inreceipt/:id", GetItemsInReceiptHandler(db))
 
 		// Add new item
-		items.POST("", PostItemsHandler(db, v))
+		items.POST("", PostItemsHandler(db, v, g))
 
 		// Add item to receipts
-		items.POST("/inreceipt", PostItemsInReceiptHandler(db, v))
+		items.POST("/inreceipt", PostItemsInReceiptHandler(db, v, g))
 
 		// Update item
 		items.PUT("", PutItemsHandler(db, v))
 
 		// Update item from specific receipt
-		items.PUT("/inreceipt", PutItemsInReceiptHandler(db))
+		items.PUT("/inreceipt", PutItemsInReceiptHandler(db, v))
 
 		// Delete item
 		items.DELETE("", DeleteItemsHandler(db, v))
@@ -128,14 +472,78 @@ func main() {
 		receipts.GET("", GetReceiptsHandler(db))
 
 		// Add new receipt
-		receipts.POST("", PostReceiptsHandler(db, v))
+		receipts.POST("", PostReceiptsHandler(db, v, g))
 
 		// Update receipt
 		receipts.PUT("", PutReceiptsHandler(db, v))
 
+		// Update receipt with id in the path
+		receipts.PUT("/:id", PutReceiptsHandler(db, v))
+
 		// Delete receipt
 		receipts.DELETE("", DeleteReceiptsHandler(db, v))
 	}
 
-	router.Run(":" + os.Getenv("PORT"))
+	me := router.Group("/me")
+	me.Use(TokenVerificationMiddleware(db))
+	{
+		// Get preferences of the user
+		me.GET("/preferences", GetPreferencesHandler(db))
+
+		// Update preferences of the user
+		me.PUT("/preferences", PutPreferencesHandler(db, v))
+	}
+
+	reports := router.Group("/reports")
+	reports.Use(requireFeature(features, "reports"), TokenVerificationMiddleware(db))
+	{
+		// Get the location with the most receipts or spending
+		reports.GET("/top-location", GetTopLocationHandler(db))
+
+		// Get the number of locations in every country
+		reports.GET("/locations-by-country", GetLocationsByCountryHandler(db))
+	}
+
+	admin := router.Group("/admin")
+	admin.Use(TokenVerificationMiddleware(db), AdminMiddleware(os.Getenv("ADMIN_USERS")))
+	{
+		// Get database connection pool statistics
+		admin.GET("/db-stats", GetDBStatsHandler(db))
+
+		// Get report of locations with data problems
+		admin.GET("/integrity/locations", GetLocationsIntegrityHandler(db))
+
+		// Insert demo locations for the user, only when seeding is enabled
+		if os.Getenv("ENABLE_SEED") == "true" {
+			admin.POST("/seed", SeedDemoLocationsHandler(db, n, g))
+		}
+	}
+
+	// Metrics are served on their own port, so they can be scraped without
+	// a token and aren't reachable through the public one.
+	if metricsPort := os.Getenv("METRICS_PORT"); metricsPort != "" {
+		registry := prometheus.NewRegistry()
+		if err := RegisterDBStatsGauges(registry, db); err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		metrics := http.NewServeMux()
+		metrics.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		go func () {
+			log.Fatalln(http.ListenAndServe(":"+metricsPort, metrics))
+		}()
+	}
+
+	server := &http.Server{
+		Addr: ":" + os.Getenv("PORT"),
+		Handler: router,
+	}
+
+	// HTTP/2 is enabled by net/http automatically when serving over TLS.
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile != "" && keyFile != "" {
+		log.Fatalln(server.ListenAndServeTLS(certFile, keyFile))
+	}
+
+	log.Fatalln(server.ListenAndServe())
 }
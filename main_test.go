@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+func TestOwnedIDs(t *testing.T) {
+	db := newTestDB(t)
+	db.Writer().MustExec("insert into locations (public_id, name, address, category, created_by) values ('first', 'First Shop', '1 Main Street', 'food', 1), ('second', 'Second Shop', '2 Main Street', null, 1), ('other', 'Other Shop', '3 Main Street', 'food', 2)")
+
+	// More ids than fit in a single query.
+	ids := []string{"first", "second", "other"}
+	for i := 0; i < maxQueryParams*2; i++ {
+		ids = append(ids, fmt.Sprintf("missing%d", i))
+	}
+
+	owned, err := ownedIDs(context.Background(), db.Reader(), "locations", ids, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := owned["first"]; !ok || len(owned) != 2 {
+		t.Fatalf("expected first and second to be owned, got %v", owned)
+	}
+	if missing := missingIDs(ids, owned); len(missing) != len(ids)-2 || missing[0] != "other" {
+		t.Fatalf("expected every other id to be missing, got %d starting with %v", len(missing), missing[0])
+	}
+
+	owned, err = ownedIDs(context.Background(), db.Reader(), "locations", ids, 1, sq.Eq{"category": "food"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := owned["first"]; !ok || len(owned) != 1 {
+		t.Fatalf("expected only first to be owned with the condition, got %v", owned)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NameSuggester is implemented by anything that can come up with a location
+// name from its address. It's used to fill in the name of new locations that
+// are created with an address only.
+type NameSuggester interface {
+	SuggestName(address string) string
+}
+
+// NoopNameSuggester : Name suggester that never suggests a name, so the name of new locations stays required
+type NoopNameSuggester struct{}
+
+// SuggestName returns an empty name.
+func (NoopNameSuggester) SuggestName(address string) string {
+	return ""
+}
+
+// StreetNameSuggester : Name suggester that names locations after the first line of their address
+type StreetNameSuggester struct{}
+
+// SuggestName returns the part of the address before the first comma, which
+// is usually the street and number.
+func (StreetNameSuggester) SuggestName(address string) string {
+	return strings.TrimSpace(strings.SplitN(address, ",", 2)[0])
+}
+
+// NewNameSuggester returns the name suggester with the specified name. Empty
+// name returns the no-op suggester.
+func NewNameSuggester(name string) (NameSuggester, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return NoopNameSuggester{}, nil
+	case "street":
+		return StreetNameSuggester{}, nil
+	default:
+		return nil, fmt.Errorf("unknown name suggester %q", name)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+	"github.com/jmoiron/sqlx"
+)
+
+// UserPreferences : Structure that should be used for getting preferences of a user from database
+type UserPreferences struct {
+	DefaultCurrency *string `db:"default_currency" json:"defaultCurrency"`
+	Locale *string `db:"locale" json:"locale"`
+}
+
+// PreferencesPutBody : Structure that should be used for getting json from body of a put request for user preferences
+type PreferencesPutBody struct {
+	DefaultCurrency string `json:"defaultCurrency" validate:"omitempty,iso4217"`
+	Locale string `json:"locale" validate:"omitempty,locale"`
+}
+
+// userPreferences gets the preferences of the user with specified public id.
+func userPreferences(requestCtx context.Context, q sqlx.QueryerContext, publicID string) (UserPreferences, error) {
+	var preferences UserPreferences
+
+	queryString, queryStringArgs, err := sq.Select("default_currency, locale").From("users").Where(sq.Eq{"public_id": publicID}).ToSql()
+	if err != nil {
+		return preferences, err
+	}
+
+	err = sqlx.GetContext(requestCtx, q, &preferences, queryString, queryStringArgs...)
+	return preferences, err
+}
+
+// GetPreferencesHandler is a Gin handler function for getting preferences of
+// the user.
+func GetPreferencesHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		preferences, err := userPreferences(ctx.Request.Context(), db.Reader(), createdBy)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, preferences)
+	}
+}
+
+// PutPreferencesHandler is a Gin handler function for updating preferences of
+// the user. Preferences that aren't sent are cleared.
+func PutPreferencesHandler(db *DB, v *validator.Validate) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var preferencesData PreferencesPutBody
+		if err := ctx.ShouldBindJSON(&preferencesData); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err := v.Struct(preferencesData)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		queryString, queryStringArgs, err := sq.Update("users").Set("default_currency", nullString(preferencesData.DefaultCurrency)).Set("locale", nullString(preferencesData.Locale)).Where(sq.Eq{"public_id": createdBy}).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			_, err := tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
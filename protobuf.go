@@ -0,0 +1,105 @@
+package main
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// protobufContentType is the content type of Protocol Buffers responses.
+const protobufContentType = "application/x-protobuf"
+
+// LocationMessage : Structure that is used for sending a location as the Location message from locations.proto
+type LocationMessage struct {
+	ID string `protobuf:"bytes,1,opt,name=id,proto3"`
+	Name string `protobuf:"bytes,2,opt,name=name,proto3"`
+	Address string `protobuf:"bytes,3,opt,name=address,proto3"`
+	NormalizedAddress string `protobuf:"bytes,4,opt,name=normalized_address,json=normalizedAddress,proto3"`
+	Street string `protobuf:"bytes,5,opt,name=street,proto3"`
+	City string `protobuf:"bytes,6,opt,name=city,proto3"`
+	PostalCode string `protobuf:"bytes,7,opt,name=postal_code,json=postalCode,proto3"`
+	Country string `protobuf:"bytes,8,opt,name=country,proto3"`
+	Region string `protobuf:"bytes,9,opt,name=region,proto3"`
+	IsDefault bool `protobuf:"varint,10,opt,name=is_default,json=isDefault,proto3"`
+	Favorite bool `protobuf:"varint,11,opt,name=favorite,proto3"`
+	Active bool `protobuf:"varint,12,opt,name=active,proto3"`
+	Metadata string `protobuf:"bytes,13,opt,name=metadata,proto3"`
+	VisitCount int64 `protobuf:"varint,14,opt,name=visit_count,json=visitCount,proto3"`
+	CreatedAt string `protobuf:"bytes,15,opt,name=created_at,json=createdAt,proto3"`
+	UpdatedAt string `protobuf:"bytes,16,opt,name=updated_at,json=updatedAt,proto3"`
+	SortOrder int64 `protobuf:"varint,17,opt,name=sort_order,json=sortOrder,proto3"`
+	Category string `protobuf:"bytes,18,opt,name=category,proto3"`
+}
+
+// Reset clears the message.
+func (message *LocationMessage) Reset() { *message = LocationMessage{} }
+
+// String returns the message in protobuf text format.
+func (message *LocationMessage) String() string { return proto.CompactTextString(message) }
+
+// ProtoMessage marks the structure as a protobuf message.
+func (*LocationMessage) ProtoMessage() {}
+
+// LocationListMessage : Structure that is used for sending a list of locations as the LocationList message from locations.proto
+type LocationListMessage struct {
+	Locations []*LocationMessage `protobuf:"bytes,1,rep,name=locations,proto3"`
+}
+
+// Reset clears the message.
+func (message *LocationListMessage) Reset() { *message = LocationListMessage{} }
+
+// String returns the message in protobuf text format.
+func (message *LocationListMessage) String() string { return proto.CompactTextString(message) }
+
+// ProtoMessage marks the structure as a protobuf message.
+func (*LocationListMessage) ProtoMessage() {}
+
+// stringValue returns the string or an empty one if it's not set.
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+// toMessage converts the location to its protobuf message.
+func (location Location) toMessage() *LocationMessage {
+	metadata, _ := location.Metadata.MarshalJSON()
+
+	sortOrder := 0
+	if location.SortOrder != nil {
+		sortOrder = *location.SortOrder
+	}
+
+	return &LocationMessage{
+		ID: location.PublicID,
+		Name: location.Name,
+		Address: location.Address,
+		NormalizedAddress: location.NormalizedAddress,
+		Street: stringValue(location.Street),
+		City: stringValue(location.City),
+		PostalCode: stringValue(location.PostalCode),
+		Country: stringValue(location.Country),
+		Region: stringValue(location.Region),
+		IsDefault: location.IsDefault,
+		Favorite: location.Favorite,
+		Active: location.Active,
+		Metadata: string(metadata),
+		VisitCount: int64(location.VisitCount),
+		CreatedAt: location.CreatedAt.Format(time.RFC3339Nano),
+		UpdatedAt: location.UpdatedAt.Format(time.RFC3339Nano),
+		SortOrder: int64(sortOrder),
+		Category: stringValue(location.Category),
+	}
+}
+
+// locationListMessage converts locations to the protobuf message of a list.
+func locationListMessage(locations []Location) *LocationListMessage {
+	list := &LocationListMessage{Locations: make([]*LocationMessage, 0, len(locations))}
+	for _, location := range locations {
+		list.Locations = append(list.Locations, location.toMessage())
+	}
+
+	return list
+}
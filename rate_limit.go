@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitWindow : Structure that holds the number of requests a user made in the current window
+type rateLimitWindow struct {
+	start time.Time
+	count int
+}
+
+// RateLimitMiddleware limits the number of requests a user can make to the
+// routes it's used on to limit requests per window. It has to be used after
+// the token verification middleware since requests are counted per user.
+func RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
+	var mutex sync.Mutex
+	windows := map[string]*rateLimitWindow{}
+
+	return func (ctx *gin.Context) {
+		userID, userIDExists := GetUserID(ctx)
+		if !userIDExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		now := time.Now()
+
+		mutex.Lock()
+		userWindow, userWindowExists := windows[userID]
+		if !userWindowExists || now.Sub(userWindow.start) >= window {
+			// Drop windows that have expired so the map doesn't grow forever.
+			for id, w := range windows {
+				if now.Sub(w.start) >= window {
+					delete(windows, id)
+				}
+			}
+
+			userWindow = &rateLimitWindow{start: now}
+			windows[userID] = userWindow
+		}
+		userWindow.count++
+		allowed := userWindow.count <= limit
+		mutex.Unlock()
+
+		if !allowed {
+			ctx.String(http.StatusTooManyRequests, "Too many requests, please slow down!")
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
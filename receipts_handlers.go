@@ -8,7 +8,6 @@ import (
 	sq "github.com/Masterminds/squirrel"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
-	"github.com/jkomyno/nanoid"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -44,21 +43,29 @@ type Receipt struct {
 	UpdatedAt time.Time `db:"updated_at" json:"updatedAt"`
 }
 
+// ReceiptLocation : Structure that should be used for getting the location fields that are sent together with receipts from database
+type ReceiptLocation struct {
+	PublicID string `json:"id"`
+	Name string `json:"name"`
+	Address string `json:"address"`
+	Active bool `json:"active"`
+}
+
 // ReceiptWithData : Structure that should be used for getting receipt information including names, addresses, and everything else from receipts location from database
 type ReceiptWithData struct {
 	PublicID string `json:"id"`
 	CreatedBy string `json:"createdBy"`
-	Location Location `json:"location"`
+	Location ReceiptLocation `json:"location"`
 	TotalPrice sql.NullFloat64 `json:"totalPrice"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
-func GetReceiptsHandler(db *sqlx.DB) gin.HandlerFunc {
+func GetReceiptsHandler(db *DB) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -78,7 +85,7 @@ func GetReceiptsHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		query := sq.Select("receipts.public_id, locations.public_id AS location_id, users.public_id AS created_by, locations.name AS name, locations.address AS address, receipts.created_at, receipts.updated_at, SUM(items.price * items_in_receipt.amount) AS total_price").From("receipts").Join("locations ON locations.id = receipts.location_id").Join("users ON users.id = receipts.created_by").LeftJoin("items_in_receipt ON items_in_receipt.receipt_id = receipts.id").LeftJoin("items ON items.id = items_in_receipt.item_id").GroupBy("receipts.id")
+		query := sq.Select("receipts.public_id, locations.public_id AS location_id, users.public_id AS created_by, locations.name AS name, locations.address AS address, locations.active AS active, receipts.created_at, receipts.updated_at, SUM(items.price * items_in_receipt.amount) AS total_price").From("receipts").Join("locations ON locations.id = receipts.location_id").Join("users ON users.id = receipts.created_by").LeftJoin("items_in_receipt ON items_in_receipt.receipt_id = receipts.id").LeftJoin("items ON items.id = items_in_receipt.item_id").GroupBy("receipts.id")
 
 		if searchQuery.PublicID != "" {
 			query = query.Where(sq.Eq{"receipts.public_id": searchQuery.PublicID})
@@ -98,7 +105,7 @@ func GetReceiptsHandler(db *sqlx.DB) gin.HandlerFunc {
 		}
 
 		receipts := []ReceiptWithData{}
-		rows, err := db.Queryx(queryString, queryStringArgs...)
+		rows, err := db.Reader().QueryxContext(ctx.Request.Context(), queryString, queryStringArgs...)
 
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
@@ -107,7 +114,7 @@ func GetReceiptsHandler(db *sqlx.DB) gin.HandlerFunc {
 
 		for rows.Next() {
 			receipt := ReceiptWithData{}
-			err := rows.Scan(&receipt.PublicID, &receipt.Location.PublicID, &receipt.CreatedBy, &receipt.Location.Name, &receipt.Location.Address, &receipt.CreatedAt, &receipt.UpdatedAt, &receipt.TotalPrice)
+			err := rows.Scan(&receipt.PublicID, &receipt.Location.PublicID, &receipt.CreatedBy, &receipt.Location.Name, &receipt.Location.Address, &receipt.Location.Active, &receipt.CreatedAt, &receipt.UpdatedAt, &receipt.TotalPrice)
 
 			if err != nil {
 				ctx.String(http.StatusInternalServerError, err.Error())
@@ -117,15 +124,50 @@ func GetReceiptsHandler(db *sqlx.DB) gin.HandlerFunc {
 			receipts = append(receipts, receipt)
 		}
 
-		ctx.JSON(http.StatusOK, receipts)
+		tz := RequestTimezone(ctx)
+		for i := range receipts {
+			receipts[i].localize(tz)
+		}
+
+		// A single receipt gets its ETag, so it can be updated with If-Match.
+		if searchQuery.PublicID != "" && len(receipts) == 1 {
+			ctx.Header("ETag", entityETag(receipts[0].PublicID, receipts[0].UpdatedAt))
+		}
+
+		ListResponse(ctx, http.StatusOK, receipts, queryString, queryStringArgs)
 	}
 }
 
-func PostReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+// addLocationVisits changes the visit count of a location by delta inside a
+// transaction, so it always matches the number of receipts of the location.
+func addLocationVisits(tx *sqlx.Tx, locationID int, delta int64) error {
+	queryString, queryStringArgs, err := sq.Update("locations").Set("visit_count", sq.Expr("visit_count + ?", delta)).Where(sq.Eq{"id": locationID}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(queryString, queryStringArgs...)
+	return err
+}
+
+// removeReceiptVisit decreases the visit count of the location a receipt is
+// currently in. It should be called before the receipt is deleted or moved to
+// another location.
+func removeReceiptVisit(tx *sqlx.Tx, receiptPublicID string, userID int) error {
+	queryString, queryStringArgs, err := sq.Update("locations").Set("visit_count", sq.Expr("visit_count - 1")).Where("id = (SELECT location_id FROM receipts WHERE public_id = ? AND created_by = ?)", receiptPublicID, userID).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(queryString, queryStringArgs...)
+	return err
+}
+
+func PostReceiptsHandler(db *DB, v *validator.Validate, g IDGenerator) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -149,14 +191,14 @@ func PostReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 		}
 
 		location := StructID{}
-		if err := db.Get(&location, locationIDQueryString, locationIDQueryStringArgs...); err != nil {
+		if err := db.Reader().GetContext(ctx.Request.Context(), &location, locationIDQueryString, locationIDQueryStringArgs...); err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
-		uuid, err := nanoid.Nanoid()
+		uuid, err := g.NewID()
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
@@ -185,31 +227,26 @@ func PostReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
+				return err
+			}
 
-		if err := tx.Commit(); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+			return addLocationVisits(tx, location.ID, 1)
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		ctx.Status(http.StatusOK)
+		ctx.Status(http.StatusCreated)
 	}
 }
 
-func PutReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+func PutReceiptsHandler(db *DB, v *validator.Validate) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -219,16 +256,26 @@ func PutReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
+		if !bindPathID(ctx, &receiptData.PublicID) {
+			return
+		}
+
+		ifMatch, ifMatchOk := ifMatchHeader(ctx)
+		if !ifMatchOk {
+			return
+		}
+
 		err := v.Struct(receiptData)
 		if err != nil {
 			ctx.String(http.StatusBadRequest, err.Error())
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		query := sq.Update("receipts")
 
+		var newLocationID int
 		if receiptData.LocationID != "" {
 			locationQuery := sq.Select("id").From("locations").Where(sq.Eq{"public_id": receiptData.LocationID})
 
@@ -239,15 +286,17 @@ func PutReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			}
 
 			location := StructID{}
-			if err := db.Get(&location, locationQueryString, locationQueryStringArgs...); err != nil {
+			if err := db.Reader().GetContext(ctx.Request.Context(), &location, locationQueryString, locationQueryStringArgs...); err != nil {
 				ctx.String(http.StatusInternalServerError, err.Error())
 				return
 			}
 
 			query = query.Set("location_id", location.ID)
+			newLocationID = location.ID
 		}
 
-		query = query.Set("updated_at", time.Now()).Where(sq.Eq{"public_id": receiptData.PublicID, "created_by": user.ID})
+		now := time.Now().UTC()
+		query = query.Set("updated_at", now).Where(sq.Eq{"public_id": receiptData.PublicID, "created_by": user.ID})
 
 		queryString, queryStringArgs, err := query.ToSql()
 		if err != nil {
@@ -255,31 +304,61 @@ func PutReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		tx, err := db.Begin()
+		currentLocationQueryString, currentLocationQueryStringArgs, err := sq.Select("location_id AS id").From("receipts").Where(sq.Eq{"public_id": receiptData.PublicID, "created_by": user.ID}).ToSql()
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if err := checkIfMatch(tx, "receipts", receiptData.PublicID, ifMatch); err != nil {
+				return err
+			}
 
-		if err := tx.Commit(); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+			// The location the receipt is in has to be read before it's
+			// moved, so its visit can be removed from it.
+			var currentLocation StructID
+			if newLocationID != 0 {
+				if err := tx.Get(&currentLocation, currentLocationQueryString, currentLocationQueryStringArgs...); err != nil && err != sql.ErrNoRows {
+					return err
+				}
+			}
+
+			result, err := tx.Exec(queryString, queryStringArgs...)
+			if err != nil {
+				return err
+			}
+
+			updated, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+
+			// Receipts that don't exist or belong to another user aren't
+			// updated, and neither are visit counts for them.
+			if newLocationID == 0 || updated != 1 {
+				return nil
+			}
+
+			if err := addLocationVisits(tx, currentLocation.ID, -1); err != nil {
+				return err
+			}
+			return addLocationVisits(tx, newLocationID, 1)
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		ctx.Status(http.StatusOK)
+		ctx.Header("ETag", entityETag(receiptData.PublicID, now))
+		ctx.Status(http.StatusNoContent)
 	}
 }
 
-func DeleteReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
+func DeleteReceiptsHandler(db *DB, v *validator.Validate) gin.HandlerFunc {
 	return func (ctx *gin.Context) {
 		createdBy, createdByExists := GetUserID(ctx)
 		if !createdByExists {
-			ctx.String(http.StatusUnauthorized, "User id not found in authorization token.")
+			userIDErrorResponse(ctx)
 			return
 		}
 
@@ -295,7 +374,7 @@ func DeleteReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		user := PublicToPrivateUserID(db, createdBy)
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
 
 		query := sq.Delete("receipts").Where(sq.Eq{"public_id": receiptData.PublicID, "created_by": user.ID})
 
@@ -305,22 +384,18 @@ func DeleteReceiptsHandler(db *sqlx.DB, v *validator.Validate) gin.HandlerFunc {
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		if _, err := tx.Exec(queryString, queryStringArgs...); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
+		if err := withTx(ctx.Request.Context(), db.Writer(), func (tx *sqlx.Tx) error {
+			if err := removeReceiptVisit(tx, receiptData.PublicID, user.ID); err != nil {
+				return err
+			}
 
-		if err := tx.Commit(); err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
+			_, err := tx.Exec(queryString, queryStringArgs...)
+			return err
+		}); err != nil {
+			TxErrorResponse(ctx, err)
 			return
 		}
 
-		ctx.Status(http.StatusOK)
+		ctx.Status(http.StatusNoContent)
 	}
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator"
+)
+
+// locationVisits gets the visit counts of locations by public id.
+func locationVisits(t *testing.T, db *DB) map[string]int {
+	t.Helper()
+
+	rows := []struct {
+		PublicID string `db:"public_id"`
+		VisitCount int `db:"visit_count"`
+	}{}
+	if err := db.Reader().Select(&rows, "select public_id, visit_count from locations"); err != nil {
+		t.Fatal(err)
+	}
+
+	visits := map[string]int{}
+	for _, row := range rows {
+		visits[row.PublicID] = row.VisitCount
+	}
+
+	return visits
+}
+
+func TestPutReceiptVisitCounts(t *testing.T) {
+	db := newTestDB(t)
+	db.Writer().MustExec("insert into locations (id, public_id, name, address, created_by, visit_count) values (1, 'first', 'First Shop', '1 Main Street', 1, 1), (2, 'second', 'Second Shop', '2 Main Street', 1, 0)")
+	db.Writer().MustExec("insert into receipts (public_id, location_id, created_by) values ('receipt', 1, 1)")
+
+	v := validator.New()
+	if err := RegisterValidations(v); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		userID string
+		body string
+		visits map[string]int
+	}{
+		{"u1", `{"id": "missing", "locationId": "second"}`, map[string]int{"first": 1, "second": 0}},
+		{"u2", `{"id": "receipt", "locationId": "second"}`, map[string]int{"first": 1, "second": 0}},
+		{"u1", `{"id": "receipt", "locationId": "second"}`, map[string]int{"first": 0, "second": 1}},
+	} {
+		router := newTestRouter(test.userID)
+		router.PUT("/receipts", PutReceiptsHandler(db, v))
+
+		if recorder := serveJSON(router, "PUT", "/receipts", test.body); recorder.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		visits := locationVisits(t, db)
+		for publicID, expected := range test.visits {
+			if visits[publicID] != expected {
+				t.Fatalf("expected %d visits of %s after %s updated %s, got %v", expected, publicID, test.userID, test.body, visits)
+			}
+		}
+	}
+}
+
+func TestPutReceiptIfMatch(t *testing.T) {
+	db := newTestDB(t)
+	db.Writer().MustExec("insert into locations (id, public_id, name, address, created_by) values (1, 'shop', 'Corner Shop', '1 Main Street', 1)")
+	db.Writer().MustExec("insert into receipts (public_id, location_id, created_by) values ('receipt', 1, 1)")
+
+	v := validator.New()
+	if err := RegisterValidations(v); err != nil {
+		t.Fatal(err)
+	}
+
+	router := newTestRouter("u1")
+	router.GET("/receipts", GetReceiptsHandler(db))
+	router.PUT("/receipts", PutReceiptsHandler(db, v))
+
+	recorder := serveJSON(router, "GET", "/receipts?id=receipt", "")
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d with %q", recorder.Code, etag)
+	}
+
+	for _, test := range []struct {
+		ifMatch string
+		status int
+	}{
+		{"W/" + etag, http.StatusPreconditionFailed},
+		{etag, http.StatusNoContent},
+		{etag, http.StatusPreconditionFailed},
+	} {
+		request := httptest.NewRequest("PUT", "/receipts", strings.NewReader(`{"id": "receipt"}`))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("If-Match", test.ifMatch)
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Fatalf("expected %d for If-Match %s, got %d: %s", test.status, test.ifMatch, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestGetReceiptsLocation(t *testing.T) {
+	db := newTestDB(t)
+	db.Writer().MustExec("insert into locations (id, public_id, name, address, created_by, visit_count, favorite) values (1, 'shop', 'Corner Shop', '1 Main Street', 1, 1, 1)")
+	db.Writer().MustExec("insert into receipts (public_id, location_id, created_by) values ('receipt', 1, 1)")
+
+	router := newTestRouter("u1")
+	router.GET("/receipts", GetReceiptsHandler(db))
+
+	recorder := serveJSON(router, "GET", "/receipts?id=receipt", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var receipts []struct {
+		Location map[string]interface{} `json:"location"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &receipts); err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 1 {
+		t.Fatalf("expected 1 receipt, got %s", recorder.Body.String())
+	}
+
+	// Only fields that are read from the location are sent, so none of
+	// them are zero values standing in for what wasn't read.
+	expected := map[string]interface{}{"id": "shop", "name": "Corner Shop", "address": "1 Main Street", "active": true}
+	if !reflect.DeepEqual(receipts[0].Location, expected) {
+		t.Fatalf("expected location %v, got %v", expected, receipts[0].Location)
+	}
+}
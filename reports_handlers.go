@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// TopLocationQuery : Structure that should be used for getting query data on get request for the top location report
+type TopLocationQuery struct {
+	Metric string `form:"metric"`
+	From string `form:"from"`
+	To string `form:"to"`
+}
+
+// LocationTotals : Structure that should be used for getting receipt totals of a location from database
+type LocationTotals struct {
+	LocationID int `db:"location_id" json:"-"`
+	Receipts int `db:"receipts" json:"receipts"`
+	Spend float64 `db:"spend" json:"spend"`
+}
+
+// TopLocation : Structure that is used as a response of a top location report request
+type TopLocation struct {
+	Location Location `json:"location"`
+	LocationTotals
+	Currency *string `json:"currency"`
+}
+
+// topLocationMetrics maps the metrics locations can be ranked by to the
+// columns they're ordered by.
+var topLocationMetrics = map[string]string{
+	"receipts": "receipts",
+	"spend": "spend",
+}
+
+// GetTopLocationHandler is a Gin handler function for getting the location of
+// a user that ranks highest by number of receipts or money spent in receipts
+// created in a date range.
+func GetTopLocationHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		var reportQuery TopLocationQuery
+		if err := ctx.ShouldBindQuery(&reportQuery); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if reportQuery.Metric == "" {
+			reportQuery.Metric = "receipts"
+		}
+
+		metricColumn, metricExists := topLocationMetrics[reportQuery.Metric]
+		if !metricExists {
+			ctx.String(http.StatusBadRequest, "Metric must be one of receipts or spend!")
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		// Receipts are counted distinctly since joining items repeats them.
+		query := sq.Select("receipts.location_id, COUNT(DISTINCT receipts.id) AS receipts, COALESCE(SUM(items.price * items_in_receipt.amount), 0) AS spend").From("receipts").LeftJoin("items_in_receipt ON items_in_receipt.receipt_id = receipts.id").LeftJoin("items ON items.id = items_in_receipt.item_id").Where(sq.Eq{"receipts.created_by": user.ID})
+
+		query, err := buildListQuery(query, ListParams{From: reportQuery.From, To: reportQuery.To}, ctx.FullPath(), nil, "receipts.created_at", "")
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		queryString, queryStringArgs, err := query.GroupBy("receipts.location_id").OrderBy(metricColumn+" DESC", "receipts.location_id").Limit(1).ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Totals, the location and preferences are read in one transaction,
+		// so receipts and locations changed while the report is built can't
+		// make them disagree.
+		var top TopLocation
+		found := true
+		if err := withReadTx(ctx.Request.Context(), db.Reader(), func (tx *sqlx.Tx) error {
+			if err := tx.Get(&top.LocationTotals, queryString, queryStringArgs...); err != nil {
+				if err == sql.ErrNoRows {
+					found = false
+					return nil
+				}
+				return err
+			}
+
+			locationQueryString, locationQueryStringArgs, err := sq.Select(locationColumns).From("locations").Where(sq.Eq{"id": top.LocationID}).ToSql()
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Get(&top.Location, locationQueryString, locationQueryStringArgs...); err != nil {
+				return err
+			}
+
+			// Spend is in the default currency of the user, if they've set one.
+			preferences, err := userPreferences(ctx.Request.Context(), tx, createdBy)
+			if err != nil {
+				return err
+			}
+			top.Currency = preferences.DefaultCurrency
+
+			return nil
+		}); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !found {
+			ctx.Status(http.StatusNoContent)
+			return
+		}
+
+		top.Location.localize(RequestTimezone(ctx))
+		ctx.JSON(http.StatusOK, top)
+	}
+}
+
+// LocationsByCountry : Structure that should be used for getting the number of locations in a country from database
+type LocationsByCountry struct {
+	Country string `db:"country" json:"country"`
+	Count int `db:"count" json:"count"`
+}
+
+// GetLocationsByCountryHandler is a Gin handler function for getting the
+// number of locations of a user in every country. Locations without a country
+// are counted as unknown.
+func GetLocationsByCountryHandler(db *DB) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		createdBy, createdByExists := GetUserID(ctx)
+		if !createdByExists {
+			userIDErrorResponse(ctx)
+			return
+		}
+
+		user := PublicToPrivateUserID(db.Reader(), createdBy)
+
+		queryString, queryStringArgs, err := sq.Select("COALESCE(NULLIF(country, ''), 'unknown') AS country, COUNT(*) AS count").From("locations").Where(sq.Eq{"created_by": user.ID}).GroupBy("1").OrderBy("count DESC", "country").ToSql()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		countries := []LocationsByCountry{}
+		if err := db.Reader().SelectContext(ctx.Request.Context(), &countries, queryString, queryStringArgs...); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, countries)
+	}
+}
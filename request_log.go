@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redactedLogFields are the fields holding personal information that are
+// masked when they are logged.
+var redactedLogFields = map[string]bool{
+	"address": true,
+	"normalizedAddress": true,
+	"street": true,
+	"city": true,
+	"postalCode": true,
+	"phone": true,
+}
+
+// redactValue masks a value, keeping only its first 3 characters so log
+// entries can still be told apart.
+func redactValue(value string) string {
+	runes := []rune(value)
+	if len(runes) > 3 {
+		runes = runes[:3]
+	}
+
+	return string(runes) + "***"
+}
+
+// redactForLog returns a copy of a decoded JSON value or query arguments with
+// the values of redacted fields masked, at any depth.
+func redactForLog(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(value))
+		for key, field := range value {
+			if redactedLogFields[key] {
+				if text, ok := field.(string); ok {
+					redacted[key] = redactValue(text)
+					continue
+				}
+			}
+
+			redacted[key] = redactForLog(field)
+		}
+		return redacted
+	case map[string][]string:
+		redacted := make(map[string][]string, len(value))
+		for key, values := range value {
+			redacted[key] = values
+			if redactedLogFields[key] {
+				redacted[key] = make([]string, len(values))
+				for i, text := range values {
+					redacted[key][i] = redactValue(text)
+				}
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for i, element := range value {
+			redacted[i] = redactForLog(element)
+		}
+		return redacted
+	default:
+		return v
+	}
+}
+
+// RequestLogMiddleware logs query arguments and JSON bodies of requests with
+// personal information redacted. Bodies that aren't JSON aren't logged.
+func RequestLogMiddleware() gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		entry := map[string]interface{}{
+			"method": ctx.Request.Method,
+			"path": ctx.Request.URL.Path,
+			"query": redactForLog(map[string][]string(ctx.Request.URL.Query())),
+		}
+
+		if ctx.Request.Body != nil && ctx.ContentType() == "application/json" {
+			body, err := ioutil.ReadAll(ctx.Request.Body)
+			if err == nil {
+				ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+				var decoded interface{}
+				if json.Unmarshal(body, &decoded) == nil {
+					entry["body"] = redactForLog(decoded)
+				}
+			}
+		}
+
+		if encoded, err := json.Marshal(entry); err == nil {
+			log.Println(string(encoded))
+		}
+
+		ctx.Next()
+	}
+}
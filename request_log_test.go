@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestLogMasksAddresses(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	router := newTestRouter("u1")
+	router.Use(RequestLogMiddleware())
+	router.POST("/locations", func (ctx *gin.Context) {
+		ctx.Status(http.StatusCreated)
+	})
+
+	fields := map[string]string{
+		"address": "1234 Main Street, Springfield 62704",
+		"normalizedAddress": "1234 MAIN ST SPRINGFIELD IL 62704",
+		"street": "1234 Main Street",
+		"city": "Springfield",
+		"postalCode": "62704",
+		"phone": "+1 217 555 0100",
+	}
+
+	body := `{"name": "Corner Shop", "metadata": {"contact": {"phone": "+1 217 555 0100"}}`
+	for field, value := range fields {
+		body += `, "` + field + `": "` + value + `"`
+	}
+	body += "}"
+
+	if recorder := serveJSON(router, "POST", "/locations?city=Springfield", body); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	entry := logged.String()
+	for field, value := range fields {
+		if strings.Contains(entry, value) {
+			t.Fatalf("expected %s to be masked, got %s", field, entry)
+		}
+		if !strings.Contains(entry, `"`+field+`":"`+redactValue(value)+`"`) {
+			t.Fatalf("expected masked %s in the log, got %s", field, entry)
+		}
+	}
+	if !strings.Contains(entry, "Corner Shop") {
+		t.Fatalf("expected other fields to be logged as they are, got %s", entry)
+	}
+}
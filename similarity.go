@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// similarityTokens splits text into a set of lower case words.
+func similarityTokens(text string) map[string]bool {
+	tokens := map[string]bool{}
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func (r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		tokens[token] = true
+	}
+
+	return tokens
+}
+
+// tokenSimilarity returns the Jaccard similarity of words in two texts, from
+// 0 for no shared words to 1 for the same words in any order.
+func tokenSimilarity(a string, b string) float64 {
+	aTokens, bTokens := similarityTokens(a), similarityTokens(b)
+	if len(aTokens) == 0 && len(bTokens) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for token := range aTokens {
+		if bTokens[token] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(aTokens)+len(bTokens)-shared)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// streamFlushInterval is the number of rows after which a streamed response
+// is flushed to the client.
+const streamFlushInterval = 100
+
+// ndjsonContentType is the content type of newline delimited JSON.
+const ndjsonContentType = "application/x-ndjson"
+
+// accepts checks if the client listed the media type in the Accept header.
+func accepts(ctx *gin.Context, contentType string) bool {
+	for _, accepted := range strings.Split(ctx.GetHeader("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accepted); err == nil && mediaType == contentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WantsNDJSON checks if the client asked for newline delimited JSON in the
+// Accept header instead of a JSON array.
+func WantsNDJSON(ctx *gin.Context) bool {
+	return accepts(ctx, ndjsonContentType)
+}
+
+// WantsProtobuf checks if the client asked for Protocol Buffers in the Accept
+// header instead of JSON.
+func WantsProtobuf(ctx *gin.Context) bool {
+	return accepts(ctx, protobufContentType)
+}
+
+// StreamJSONArray writes rows as a JSON array to the response one row at a
+// time, so huge results don't have to be loaded into memory. newRow should
+// return a pointer to an empty structure that a single row can be scanned
+// into, and prepare, if it's not nil, is called on every scanned row before
+// it's written. Once the first byte is written the status can't be changed anymore,
+// so errors that happen during streaming abort the response.
+func StreamJSONArray(ctx *gin.Context, rows *sqlx.Rows, newRow func () interface{}, prepare func (row interface{})) {
+	streamRows(ctx, rows, "application/json; charset=utf-8", "[", ",", "]", newRow, prepare)
+}
+
+// StreamNDJSON writes rows as newline delimited JSON, one object per line,
+// the same way StreamJSONArray writes them as an array.
+func StreamNDJSON(ctx *gin.Context, rows *sqlx.Rows, newRow func () interface{}, prepare func (row interface{})) {
+	streamRows(ctx, rows, ndjsonContentType+"; charset=utf-8", "", "", "", newRow, prepare)
+}
+
+// streamRows writes scanned rows between open and end, with separator
+// between them. Every row is followed by a new line.
+func streamRows(ctx *gin.Context, rows *sqlx.Rows, contentType string, open string, separator string, end string, newRow func () interface{}, prepare func (row interface{})) {
+	defer rows.Close()
+
+	ctx.Header("Content-Type", contentType)
+	ctx.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(ctx.Writer)
+
+	if _, err := ctx.Writer.WriteString(open); err != nil {
+		ctx.Abort()
+		return
+	}
+
+	count := 0
+	for rows.Next() {
+		row := newRow()
+		if err := rows.StructScan(row); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+
+		if prepare != nil {
+			prepare(row)
+		}
+
+		if count > 0 {
+			if _, err := ctx.Writer.WriteString(separator); err != nil {
+				ctx.Abort()
+				return
+			}
+		}
+
+		if err := encoder.Encode(row); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+
+		count++
+		if count%streamFlushInterval == 0 {
+			ctx.Writer.Flush()
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.Writer.WriteString(end)
+	ctx.Writer.Flush()
+}
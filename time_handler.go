@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerTime : Structure that is used as a response of a request for the current server time
+type ServerTime struct {
+	Time string `json:"time"`
+	EpochMillis int64 `json:"epochMillis"`
+}
+
+// GetTimeHandler is a Gin handler function for getting the current time of
+// the server, so clients can detect how far their clock is off before they
+// send timestamps. Every response also has the Date header, which net/http
+// sets, but it only has a precision of seconds.
+func GetTimeHandler(ctx *gin.Context) {
+	now := time.Now().UTC()
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, ServerTime{
+		Time: now.Format(time.RFC3339Nano),
+		EpochMillis: now.UnixNano() / int64(time.Millisecond),
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRequestTimeout is the time a request can take on routes that don't
+// have their own timeout.
+const defaultRequestTimeout = 3 * time.Second
+
+// defaultRouteTimeouts are the timeouts of routes that are expected to take
+// longer than the default, like exports that stream every location or photo
+// uploads over slow connections.
+var defaultRouteTimeouts = map[string]time.Duration{
+	"/locations/export": 30 * time.Second,
+	"/locations/export/full": 30 * time.Second,
+	"/locations/export/download/:id": 30 * time.Second,
+	"/locations/photo/:id": 30 * time.Second,
+}
+
+// RouteTimeouts : Structure that holds how long requests to each route can take
+type RouteTimeouts struct {
+	fallback time.Duration
+	routes map[string]time.Duration
+}
+
+// NewRouteTimeouts parses a comma separated list of route=duration pairs, like
+// "/locations/export=1m,/items=5s", on top of the default route timeouts.
+// Routes are matched by their full path as they're registered, so routes with
+// parameters are specified like "/locations/photo/:id". fallback is used for
+// routes that aren't in the list, if it's empty defaultRequestTimeout is used.
+func NewRouteTimeouts(list string, fallback string) (RouteTimeouts, error) {
+	timeouts := RouteTimeouts{fallback: defaultRequestTimeout, routes: map[string]time.Duration{}}
+	for route, timeout := range defaultRouteTimeouts {
+		timeouts.routes[route] = timeout
+	}
+
+	if fallback != "" {
+		timeout, err := time.ParseDuration(fallback)
+		if err != nil {
+			return timeouts, err
+		}
+		timeouts.fallback = timeout
+	}
+
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return timeouts, fmt.Errorf("Route timeout %q should be in route=duration format!", entry)
+		}
+
+		timeout, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return timeouts, err
+		}
+		timeouts.routes[strings.TrimSpace(parts[0])] = timeout
+	}
+
+	return timeouts, nil
+}
+
+// For gets the timeout of the route with specified full path.
+func (timeouts RouteTimeouts) For(route string) time.Duration {
+	if timeout, ok := timeouts.routes[route]; ok {
+		return timeout
+	}
+
+	return timeouts.fallback
+}
+
+// timeoutWriter : Structure that wraps the response writer so the response is replaced by a timeout error once the request deadline passes
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx context.Context
+	started bool
+	timedOut bool
+}
+
+// expired checks if the deadline passed before the handler started writing
+// its response. The first time it does the timeout error is written instead,
+// and everything the handler writes after that is dropped.
+func (w *timeoutWriter) expired() bool {
+	if w.timedOut {
+		return true
+	}
+	if w.started || w.ctx.Err() != context.DeadlineExceeded {
+		return false
+	}
+
+	w.timedOut = true
+	w.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w.ResponseWriter).Encode(APIError{Code: "TIMEOUT", Message: "Request took too long to process, please try again later."})
+
+	return true
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if w.expired() {
+		return
+	}
+
+	w.started = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	if w.expired() {
+		return
+	}
+
+	w.started = true
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	if w.expired() {
+		return len(data), nil
+	}
+
+	w.started = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.expired() {
+		return len(s), nil
+	}
+
+	w.started = true
+	return w.ResponseWriter.WriteString(s)
+}
+
+// TimeoutMiddleware sets a deadline on the request context depending on the
+// route, so database calls made with it are cancelled once it passes. If the
+// deadline passes before the handler responds, the client gets 503 with the
+// TIMEOUT code instead of whatever the handler responds with, or instead of
+// an empty response if the handler doesn't respond at all. Responses that
+// already started, like streamed exports, can't be replaced, so they're just
+// cut off.
+func TimeoutMiddleware(timeouts RouteTimeouts) gin.HandlerFunc {
+	return func (ctx *gin.Context) {
+		timeoutCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeouts.For(ctx.FullPath()))
+		defer cancel()
+
+		ctx.Request = ctx.Request.WithContext(timeoutCtx)
+		writer := &timeoutWriter{ResponseWriter: ctx.Writer, ctx: timeoutCtx}
+		ctx.Writer = writer
+
+		ctx.Next()
+
+		// Handlers that give up once the deadline passes may return without
+		// writing anything, which would otherwise be sent as an empty 200.
+		writer.expired()
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestTimeoutRouter creates a router that gives every request the
+// specified time to finish.
+func newTestTimeoutRouter(t *testing.T, fallback string) *gin.Engine {
+	t.Helper()
+
+	timeouts, err := NewRouteTimeouts("", fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := newTestRouter("u1")
+	router.Use(TimeoutMiddleware(timeouts))
+
+	return router
+}
+
+// requireTimeoutResponse checks that the response is the 503 timeout error.
+func requireTimeoutResponse(t *testing.T, status int, body []byte) {
+	t.Helper()
+
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d: %s", status, body)
+	}
+
+	var apiError APIError
+	if err := json.Unmarshal(body, &apiError); err != nil {
+		t.Fatalf("expected an API error, got %s", body)
+	}
+	if apiError.Code != "TIMEOUT" {
+		t.Fatalf("expected TIMEOUT code, got %q", apiError.Code)
+	}
+}
+
+func TestTimeoutMiddlewareHandlerWritesLate(t *testing.T) {
+	router := newTestTimeoutRouter(t, "10ms")
+	router.GET("/slow", func (ctx *gin.Context) {
+		<-ctx.Request.Context().Done()
+		ctx.String(http.StatusOK, "too late")
+	})
+
+	response := serveJSON(router, http.MethodGet, "/slow", "")
+	requireTimeoutResponse(t, response.Code, response.Body.Bytes())
+}
+
+func TestTimeoutMiddlewareHandlerWritesNothing(t *testing.T) {
+	router := newTestTimeoutRouter(t, "10ms")
+	router.GET("/slow", func (ctx *gin.Context) {
+		<-ctx.Request.Context().Done()
+	})
+
+	response := serveJSON(router, http.MethodGet, "/slow", "")
+	requireTimeoutResponse(t, response.Code, response.Body.Bytes())
+}
+
+func TestTimeoutMiddlewareFastHandler(t *testing.T) {
+	router := newTestTimeoutRouter(t, "1s")
+	router.GET("/fast", func (ctx *gin.Context) {
+		ctx.String(http.StatusOK, "done")
+	})
+
+	response := serveJSON(router, http.MethodGet, "/fast", "")
+	if response.Code != http.StatusOK || response.Body.String() != "done" {
+		t.Fatalf("expected 200 done, got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+// The query fails once the deadline passes, which has to end up as the
+// timeout error instead of stopping the server.
+func TestTimeoutMiddlewareCancelsQuery(t *testing.T) {
+	db := newTestDB(t)
+
+	router := newTestTimeoutRouter(t, "1ns")
+	router.GET("/items", func (ctx *gin.Context) {
+		// Makes sure the deadline passed before the query runs.
+		time.Sleep(time.Millisecond)
+		ctx.Next()
+	}, GetItemsHandler(db))
+
+	response := serveJSON(router, http.MethodGet, "/items", "")
+	requireTimeoutResponse(t, response.Code, response.Body.Bytes())
+
+	// The server is still able to answer requests after that.
+	router = newTestTimeoutRouter(t, "1s")
+	router.GET("/items", GetItemsHandler(db))
+
+	response = serveJSON(router, http.MethodGet, "/items", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body.String())
+	}
+}
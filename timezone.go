@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestTimezone gets the timezone the client wants timestamps in from the
+// tz query parameter, which should be an IANA timezone name such as
+// "Europe/Belgrade". Timestamps are always stored in UTC, which is also used
+// when the parameter is missing or isn't a known timezone.
+func RequestTimezone(ctx *gin.Context) *time.Location {
+	if tz := ctx.Query("tz"); tz != "" {
+		if location, err := time.LoadLocation(tz); err == nil {
+			return location
+		}
+	}
+
+	return time.UTC
+}
+
+// localize converts location timestamps to the specified timezone.
+func (location *Location) localize(tz *time.Location) {
+	location.CreatedAt = location.CreatedAt.In(tz)
+	location.UpdatedAt = location.UpdatedAt.In(tz)
+}
+
+// localize converts item timestamps to the specified timezone.
+func (item *Item) localize(tz *time.Location) {
+	item.CreatedAt = item.CreatedAt.In(tz)
+	item.UpdatedAt = item.UpdatedAt.In(tz)
+}
+
+// localize converts receipt timestamps to the specified timezone.
+func (receipt *ReceiptWithData) localize(tz *time.Location) {
+	receipt.CreatedAt = receipt.CreatedAt.In(tz)
+	receipt.UpdatedAt = receipt.UpdatedAt.In(tz)
+}
+
+// localize converts export job timestamps to the specified timezone.
+func (job *ExportJob) localize(tz *time.Location) {
+	job.CreatedAt = job.CreatedAt.In(tz)
+	if job.FinishedAt != nil {
+		finishedAt := job.FinishedAt.In(tz)
+		job.FinishedAt = &finishedAt
+	}
+	if job.ExpiresAt != nil {
+		expiresAt := job.ExpiresAt.In(tz)
+		job.ExpiresAt = &expiresAt
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrDatabaseBusy is returned by withTx when the database stayed busy or
+// locked through all the retries.
+var ErrDatabaseBusy = errors.New("The database is busy, please try again later.")
+
+// txMaxRetries is the number of times a busy transaction is retried before
+// giving up.
+const txMaxRetries = 5
+
+// txRetryBackoff is the base backoff between retries of a busy transaction.
+// It's doubled on every retry and jittered so concurrent writers don't retry
+// in lockstep.
+const txRetryBackoff = 10 * time.Millisecond
+
+// isBusyError checks if the error is SQLite telling that the database is
+// busy or locked by another connection.
+func isBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+
+	return false
+}
+
+// isUniqueConstraintError checks if the error is SQLite refusing a write
+// because it would violate a unique constraint.
+func isUniqueConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+
+	return false
+}
+
+// isLengthConstraintError checks if the error is SQLite refusing a write
+// because a value is longer than the database allows. Its message describes
+// which value is too long.
+func isLengthConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintTrigger || sqliteErr.ExtendedCode == sqlite3.ErrConstraintCheck
+	}
+
+	return false
+}
+
+// runTx runs fn inside a single transaction. The transaction is committed if
+// fn returns no error, and rolled back otherwise. It's also rolled back if
+// requestCtx is cancelled before it's committed.
+func runTx(requestCtx context.Context, db *sqlx.DB, fn func (tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(requestCtx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// withReadTx runs fn inside a read transaction. In WAL mode a read
+// transaction keeps seeing the database as it was at its first read until it
+// ends, so every query made in fn sees the same point in time, even if writes
+// are committed in between. The transaction is always rolled back since
+// nothing is written in it. go-sqlite3 ignores transaction options, so it's
+// only read only when db is the reader, whose connections are opened with
+// mode=ro.
+func withReadTx(requestCtx context.Context, db *sqlx.DB, fn func (tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(requestCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return fn(tx)
+}
+
+// withTx runs fn inside a transaction, retrying the whole transaction with a
+// jittered backoff when SQLite reports that the database is busy or locked.
+// If it's still busy after all retries ErrDatabaseBusy is returned. It stops
+// retrying once requestCtx is done.
+func withTx(requestCtx context.Context, db *sqlx.DB, fn func (tx *sqlx.Tx) error) error {
+	for attempt := 0; ; attempt++ {
+		err := runTx(requestCtx, db, fn)
+		if err == nil || !isBusyError(err) {
+			return err
+		}
+
+		if attempt == txMaxRetries {
+			return ErrDatabaseBusy
+		}
+		if requestCtx.Err() != nil {
+			return requestCtx.Err()
+		}
+
+		backoff := txRetryBackoff << uint(attempt)
+		time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff))))
+	}
+}
+
+// TxErrorResponse sends the response for an error returned by withTx. Busy
+// database is reported as temporarily unavailable, failed If-Match checks as
+// failed preconditions, unique constraint violations as a conflict, values
+// that are too long for the database as a bad request, and everything else as
+// an internal server error.
+// Checking the constraint error instead of looking for duplicates beforehand
+// means two concurrent writes can't both get through.
+func TxErrorResponse(ctx *gin.Context, err error) {
+	if err == ErrDatabaseBusy {
+		ctx.String(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	if err == ErrPreconditionFailed {
+		ctx.String(http.StatusPreconditionFailed, err.Error())
+		return
+	}
+
+	if isUniqueConstraintError(err) {
+		ctx.String(http.StatusConflict, "An entry with the same unique value already exists: "+err.Error())
+		return
+	}
+
+	if isLengthConstraintError(err) {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx.String(http.StatusInternalServerError, err.Error())
+}
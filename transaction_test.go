@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// lockTestDB opens a second connection to the test database and holds its
+// write lock until the returned function is called. The returned writer
+// doesn't wait for locks, so writes through it fail with SQLITE_BUSY right
+// away while the lock is held.
+func lockTestDB(t *testing.T) (*sqlx.DB, func ()) {
+	t.Helper()
+
+	locker, err := sqlx.Connect("sqlite3", "file:receipts.db?_busy_timeout=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := sqlx.Connect("sqlite3", "file:receipts.db?_busy_timeout=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func () {
+		writer.Close()
+		locker.Close()
+	})
+
+	lock, err := locker.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.Exec("insert into users (public_id, real_name) values ('locker', 'Locker')"); err != nil {
+		t.Fatal(err)
+	}
+
+	return writer, func () {
+		lock.Rollback()
+	}
+}
+
+func TestWithTxRetriesBusyDatabase(t *testing.T) {
+	newTestDB(t)
+	writer, unlock := lockTestDB(t)
+
+	go func () {
+		time.Sleep(2 * txRetryBackoff)
+		unlock()
+	}()
+
+	attempts := 0
+	err := withTx(context.Background(), writer, func (tx *sqlx.Tx) error {
+		attempts++
+		_, err := tx.Exec("insert into users (public_id, real_name) values ('u3', 'User Three')")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected the transaction to succeed once the lock is released, got %v", err)
+	}
+	if attempts < 2 {
+		t.Fatalf("expected the transaction to be retried, it was attempted %d times", attempts)
+	}
+
+	var count int
+	if err := writer.Get(&count, "select count(*) from users where public_id = 'u3'"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected the retried transaction to be committed once, got %d users", count)
+	}
+}
+
+func TestWithTxGivesUpOnBusyDatabase(t *testing.T) {
+	newTestDB(t)
+	writer, unlock := lockTestDB(t)
+	defer unlock()
+
+	attempts := 0
+	err := withTx(context.Background(), writer, func (tx *sqlx.Tx) error {
+		attempts++
+		_, err := tx.Exec("insert into users (public_id, real_name) values ('u3', 'User Three')")
+		return err
+	})
+	if err != ErrDatabaseBusy {
+		t.Fatalf("expected ErrDatabaseBusy, got %v", err)
+	}
+	if attempts != txMaxRetries+1 {
+		t.Fatalf("expected %d attempts, got %d", txMaxRetries+1, attempts)
+	}
+}
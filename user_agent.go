@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserAgentMiddleware rejects POST, PUT and DELETE requests that don't send a
+// User-Agent header, to cut down on junk writes from scripts. allowedAgents is
+// a comma separated list of User-Agent prefixes of known clients. If it's not
+// empty, writes from clients that don't match any of them are rejected too.
+// Reads are never checked.
+func UserAgentMiddleware(allowedAgents string) gin.HandlerFunc {
+	allowed := []string{}
+	for _, agent := range strings.Split(allowedAgents, ",") {
+		if agent = strings.TrimSpace(agent); agent != "" {
+			allowed = append(allowed, agent)
+		}
+	}
+
+	return func (ctx *gin.Context) {
+		switch ctx.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodDelete:
+		default:
+			ctx.Next()
+			return
+		}
+
+		userAgent := strings.TrimSpace(ctx.GetHeader("User-Agent"))
+		if userAgent == "" {
+			ctx.String(http.StatusBadRequest, "User-Agent header is required!")
+			ctx.Abort()
+			return
+		}
+
+		if len(allowed) > 0 {
+			known := false
+			for _, agent := range allowed {
+				if strings.HasPrefix(userAgent, agent) {
+					known = true
+					break
+				}
+			}
+
+			if !known {
+				ctx.String(http.StatusBadRequest, "User-Agent "+userAgent+" is not allowed to make changes!")
+				ctx.Abort()
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/go-playground/validator"
+)
+
+// iso3166Alpha2Codes is a set of all officially assigned ISO 3166-1 alpha-2
+// country codes.
+var iso3166Alpha2Codes = map[string]bool{
+	"AD": true, "AE": true, "AF": true, "AG": true, "AI": true, "AL": true, "AM": true, "AO": true, "AQ": true, "AR": true,
+	"AS": true, "AT": true, "AU": true, "AW": true, "AX": true, "AZ": true, "BA": true, "BB": true, "BD": true, "BE": true,
+	"BF": true, "BG": true, "BH": true, "BI": true, "BJ": true, "BL": true, "BM": true, "BN": true, "BO": true, "BQ": true,
+	"BR": true, "BS": true, "BT": true, "BV": true, "BW": true, "BY": true, "BZ": true, "CA": true, "CC": true, "CD": true,
+	"CF": true, "CG": true, "CH": true, "CI": true, "CK": true, "CL": true, "CM": true, "CN": true, "CO": true, "CR": true,
+	"CU": true, "CV": true, "CW": true, "CX": true, "CY": true, "CZ": true, "DE": true, "DJ": true, "DK": true, "DM": true,
+	"DO": true, "DZ": true, "EC": true, "EE": true, "EG": true, "EH": true, "ER": true, "ES": true, "ET": true, "FI": true,
+	"FJ": true, "FK": true, "FM": true, "FO": true, "FR": true, "GA": true, "GB": true, "GD": true, "GE": true, "GF": true,
+	"GG": true, "GH": true, "GI": true, "GL": true, "GM": true, "GN": true, "GP": true, "GQ": true, "GR": true, "GS": true,
+	"GT": true, "GU": true, "GW": true, "GY": true, "HK": true, "HM": true, "HN": true, "HR": true, "HT": true, "HU": true,
+	"ID": true, "IE": true, "IL": true, "IM": true, "IN": true, "IO": true, "IQ": true, "IR": true, "IS": true, "IT": true,
+	"JE": true, "JM": true, "JO": true, "JP": true, "KE": true, "KG": true, "KH": true, "KI": true, "KM": true, "KN": true,
+	"KP": true, "KR": true, "KW": true, "KY": true, "KZ": true, "LA": true, "LB": true, "LC": true, "LI": true, "LK": true,
+	"LR": true, "LS": true, "LT": true, "LU": true, "LV": true, "LY": true, "MA": true, "MC": true, "MD": true, "ME": true,
+	"MF": true, "MG": true, "MH": true, "MK": true, "ML": true, "MM": true, "MN": true, "MO": true, "MP": true, "MQ": true,
+	"MR": true, "MS": true, "MT": true, "MU": true, "MV": true, "MW": true, "MX": true, "MY": true, "MZ": true, "NA": true,
+	"NC": true, "NE": true, "NF": true, "NG": true, "NI": true, "NL": true, "NO": true, "NP": true, "NR": true, "NU": true,
+	"NZ": true, "OM": true, "PA": true, "PE": true, "PF": true, "PG": true, "PH": true, "PK": true, "PL": true, "PM": true,
+	"PN": true, "PR": true, "PS": true, "PT": true, "PW": true, "PY": true, "QA": true, "RE": true, "RO": true, "RS": true,
+	"RU": true, "RW": true, "SA": true, "SB": true, "SC": true, "SD": true, "SE": true, "SG": true, "SH": true, "SI": true,
+	"SJ": true, "SK": true, "SL": true, "SM": true, "SN": true, "SO": true, "SR": true, "SS": true, "ST": true, "SV": true,
+	"SX": true, "SY": true, "SZ": true, "TC": true, "TD": true, "TF": true, "TG": true, "TH": true, "TJ": true, "TK": true,
+	"TL": true, "TM": true, "TN": true, "TO": true, "TR": true, "TT": true, "TV": true, "TW": true, "TZ": true, "UA": true,
+	"UG": true, "UM": true, "US": true, "UY": true, "UZ": true, "VA": true, "VC": true, "VE": true, "VG": true, "VI": true,
+	"VN": true, "VU": true, "WF": true, "WS": true, "YE": true, "YT": true, "ZA": true, "ZM": true, "ZW": true,
+}
+
+// isISO3166Alpha2 validates that a field is an ISO 3166-1 alpha-2 country code.
+func isISO3166Alpha2(fl validator.FieldLevel) bool {
+	return iso3166Alpha2Codes[fl.Field().String()]
+}
+
+// iso4217Codes is a set of ISO 4217 codes of currencies that are currently in
+// use.
+var iso4217Codes = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true, "AWG": true, "AZN": true,
+	"BAM": true, "BBD": true, "BDT": true, "BGN": true, "BHD": true, "BIF": true, "BMD": true, "BND": true, "BOB": true, "BRL": true,
+	"BSD": true, "BTN": true, "BWP": true, "BYN": true, "BZD": true, "CAD": true, "CDF": true, "CHF": true, "CLP": true, "CNY": true,
+	"COP": true, "CRC": true, "CUP": true, "CVE": true, "CZK": true, "DJF": true, "DKK": true, "DOP": true, "DZD": true, "EGP": true,
+	"ERN": true, "ETB": true, "EUR": true, "FJD": true, "FKP": true, "GBP": true, "GEL": true, "GHS": true, "GIP": true, "GMD": true,
+	"GNF": true, "GTQ": true, "GYD": true, "HKD": true, "HNL": true, "HTG": true, "HUF": true, "IDR": true, "ILS": true, "INR": true,
+	"IQD": true, "IRR": true, "ISK": true, "JMD": true, "JOD": true, "JPY": true, "KES": true, "KGS": true, "KHR": true, "KMF": true,
+	"KPW": true, "KRW": true, "KWD": true, "KYD": true, "KZT": true, "LAK": true, "LBP": true, "LKR": true, "LRD": true, "LSL": true,
+	"LYD": true, "MAD": true, "MDL": true, "MGA": true, "MKD": true, "MMK": true, "MNT": true, "MOP": true, "MRU": true, "MUR": true,
+	"MVR": true, "MWK": true, "MXN": true, "MYR": true, "MZN": true, "NAD": true, "NGN": true, "NIO": true, "NOK": true, "NPR": true,
+	"NZD": true, "OMR": true, "PAB": true, "PEN": true, "PGK": true, "PHP": true, "PKR": true, "PLN": true, "PYG": true, "QAR": true,
+	"RON": true, "RSD": true, "RUB": true, "RWF": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true, "SGD": true,
+	"SHP": true, "SLE": true, "SOS": true, "SRD": true, "SSP": true, "STN": true, "SVC": true, "SYP": true, "SZL": true, "THB": true,
+	"TJS": true, "TMT": true, "TND": true, "TOP": true, "TRY": true, "TTD": true, "TWD": true, "TZS": true, "UAH": true, "UGX": true,
+	"USD": true, "UYU": true, "UZS": true, "VES": true, "VND": true, "VUV": true, "WST": true, "XAF": true, "XCD": true, "XOF": true,
+	"XPF": true, "YER": true, "ZAR": true, "ZMW": true, "ZWL": true,
+}
+
+// isISO4217 validates that a field is an ISO 4217 currency code.
+func isISO4217(fl validator.FieldLevel) bool {
+	return iso4217Codes[fl.Field().String()]
+}
+
+// localePattern matches BCP 47 language tags made of a language, optional
+// script and optional region, like "en", "sr-Latn" or "en-US".
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z][a-z]{3})?(-([A-Z]{2}|\d{3}))?$`)
+
+// isLocale validates that a field is a language tag matching localePattern.
+func isLocale(fl validator.FieldLevel) bool {
+	return localePattern.MatchString(fl.Field().String())
+}
+
+// locationCategories are the categories a location can be in. They can be
+// changed with the LOCATION_CATEGORIES environment variable.
+var locationCategories = []string{"grocery", "restaurant", "cafe", "pharmacy", "fuel", "clothing", "electronics", "other"}
+
+// parseLocationCategories parses a comma separated list of location
+// categories.
+func parseLocationCategories(list string) []string {
+	categories := []string{}
+	for _, category := range strings.Split(list, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			categories = append(categories, category)
+		}
+	}
+
+	return categories
+}
+
+// isLocationCategory validates that a field is one of locationCategories.
+func isLocationCategory(fl validator.FieldLevel) bool {
+	for _, category := range locationCategories {
+		if fl.Field().String() == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// postalCodePatterns are formats of postal codes in countries whose format is
+// known. Postal codes of other countries aren't checked.
+var postalCodePatterns = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^\d{4}$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"BA": regexp.MustCompile(`^\d{5}$`),
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"BG": regexp.MustCompile(`^\d{4}$`),
+	"BR": regexp.MustCompile(`^\d{5}-?\d{3}$`),
+	"CA": regexp.MustCompile(`^[A-Za-z]\d[A-Za-z] ?\d[A-Za-z]\d$`),
+	"CH": regexp.MustCompile(`^\d{4}$`),
+	"CZ": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"DK": regexp.MustCompile(`^\d{4}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"FI": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^[A-Za-z]{1,2}\d[A-Za-z\d]? ?\d[A-Za-z]{2}$`),
+	"GR": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"HR": regexp.MustCompile(`^\d{5}$`),
+	"HU": regexp.MustCompile(`^\d{4}$`),
+	"IN": regexp.MustCompile(`^\d{6}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+	"ME": regexp.MustCompile(`^\d{5}$`),
+	"MK": regexp.MustCompile(`^\d{4}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Za-z]{2}$`),
+	"NO": regexp.MustCompile(`^\d{4}$`),
+	"NZ": regexp.MustCompile(`^\d{4}$`),
+	"PL": regexp.MustCompile(`^\d{2}-\d{3}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"RO": regexp.MustCompile(`^\d{6}$`),
+	"RS": regexp.MustCompile(`^\d{5}$`),
+	"SE": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"SI": regexp.MustCompile(`^\d{4}$`),
+	"SK": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+}
+
+// validPostalCode checks if a postal code has the format used in the
+// country. Empty postal codes, and countries without a known format, pass.
+func validPostalCode(postalCode string, country string) bool {
+	pattern, known := postalCodePatterns[strings.ToUpper(country)]
+	return postalCode == "" || !known || pattern.MatchString(postalCode)
+}
+
+// addressHasPostalCode checks if any word or pair of adjacent words of the
+// address is a postal code in the format used in the country. Countries
+// without a known format always pass.
+func addressHasPostalCode(address string, country string) bool {
+	if _, known := postalCodePatterns[strings.ToUpper(country)]; !known {
+		return true
+	}
+
+	words := strings.FieldsFunc(address, func (r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for i, word := range words {
+		if validPostalCode(word, country) {
+			return true
+		}
+		if i > 0 && validPostalCode(words[i-1]+" "+word, country) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addressWarnings lists things that look wrong with an address but don't
+// make it invalid, since addresses are freeform.
+func addressWarnings(address string, country string) []string {
+	warnings := []string{}
+	if address == "" {
+		return warnings
+	}
+
+	if strings.IndexFunc(address, unicode.IsDigit) == -1 {
+		warnings = append(warnings, "Address has no house number.")
+	}
+	if country != "" && !addressHasPostalCode(address, country) {
+		warnings = append(warnings, "Address has no postal code in the format used in "+strings.ToUpper(country)+".")
+	}
+
+	return warnings
+}
+
+// locationPostalCodeValidation checks postal codes of location bodies
+// against the country sent with them.
+func locationPostalCodeValidation(sl validator.StructLevel) {
+	var postalCode, country string
+	switch locationData := sl.Current().Interface().(type) {
+	case LocationsPostBody:
+		postalCode, country = locationData.PostalCode, locationData.Country
+	case LocationsPutBody:
+		postalCode, country = locationData.PostalCode, locationData.Country
+	}
+
+	if !validPostalCode(postalCode, country) {
+		sl.ReportError(postalCode, "postalCode", "PostalCode", "postal_code", country)
+	}
+}
+
+// isJSONObject checks if the field holds a JSON object, not an array or any
+// other JSON value.
+func isJSONObject(fl validator.FieldLevel) bool {
+	var object map[string]interface{}
+	return json.Unmarshal(fl.Field().Bytes(), &object) == nil && object != nil
+}
+
+// jsonFieldName names fields in validation errors by their json key, so
+// errors point at the fields clients actually send.
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" || name == "" {
+		return field.Name
+	}
+
+	return name
+}
+
+// ValidationError : Structure that describes a single field that failed validation
+type ValidationError struct {
+	Field string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationErrorPath gets the path of the failed field in the validated
+// structure, with the name of the structure itself left out, like
+// "openingHours.monday.close" or "locations[2].name", so errors of nested
+// fields can be told apart from top level fields with the same name.
+func validationErrorPath(fieldError validator.FieldError) string {
+	namespace := fieldError.Namespace()
+	if separator := strings.Index(namespace, "."); separator >= 0 {
+		return namespace[separator+1:]
+	}
+
+	return fieldError.Field()
+}
+
+// formatValidationErrors turns an error returned by the validator into a
+// list of failed fields with human readable messages. Fields of nested
+// structures are named by their dotted path.
+func formatValidationErrors(err error) []ValidationError {
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []ValidationError{{Message: err.Error()}}
+	}
+
+	formatted := []ValidationError{}
+	for _, fieldError := range validationErrors {
+		formatted = append(formatted, ValidationError{
+			Field: validationErrorPath(fieldError),
+			Message: validationMessage(fieldError),
+		})
+	}
+
+	return formatted
+}
+
+// validationMessage describes why a field failed validation.
+func validationMessage(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required":
+		return "Field is required."
+	case "max":
+		if fieldError.Kind() == reflect.String {
+			return "Field can't be longer than " + fieldError.Param() + " characters."
+		}
+		if fieldError.Type() == reflect.TypeOf(json.RawMessage{}) {
+			return "Field can't be larger than " + fieldError.Param() + " bytes."
+		}
+		return "Field can't have more than " + fieldError.Param() + " entries."
+	case "iso3166_1_alpha2":
+		return "Field must be an ISO 3166-1 alpha-2 country code."
+	case "iso4217":
+		return "Field must be an ISO 4217 currency code."
+	case "locale":
+		return "Field must be a language tag like en or en-US."
+	case "json_object":
+		return "Field must be a JSON object."
+	case "location_category":
+		return "Field must be one of " + strings.Join(locationCategories, ", ") + "."
+	case "postal_code":
+		return "Field must be a postal code in the format used in " + fieldError.Param() + "."
+	case "required_without":
+		return "Field is required when " + fieldError.Param() + " isn't set."
+	default:
+		return "Field failed on the '" + fieldError.Tag() + "' validation."
+	}
+}
+
+// RegisterValidations registers custom validation tags that the validator
+// doesn't support out of the box.
+func RegisterValidations(v *validator.Validate) error {
+	v.RegisterTagNameFunc(jsonFieldName)
+
+	if err := v.RegisterValidation("iso3166_1_alpha2", isISO3166Alpha2); err != nil {
+		return err
+	}
+	if err := v.RegisterValidation("iso4217", isISO4217); err != nil {
+		return err
+	}
+	if err := v.RegisterValidation("locale", isLocale); err != nil {
+		return err
+	}
+	if err := v.RegisterValidation("json_object", isJSONObject); err != nil {
+		return err
+	}
+	if err := v.RegisterValidation("location_category", isLocationCategory); err != nil {
+		return err
+	}
+
+	v.RegisterStructValidation(locationPostalCodeValidation, LocationsPostBody{}, LocationsPutBody{})
+
+	return nil
+}